@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"time"
+)
+
+const (
+	alertFileName        = "oura-hr-alert"
+	defaultAlertCooldown = 15 * time.Minute
+)
+
+func alertCooldown() time.Duration {
+	if v := setting("OURA_HR_ALERT_COOLDOWN"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d >= 0 {
+			return d
+		}
+	}
+	return defaultAlertCooldown
+}
+
+// alertMessage describes how bpm is outside the OURA_HR_ALERT_LOW/HIGH
+// band, or returns "" if it's inside it or no band is configured.
+func alertMessage(bpm int) string {
+	if high := envInt("OURA_HR_ALERT_HIGH", 0); high > 0 && bpm > high {
+		return fmt.Sprintf("Heart rate %d BPM is above %d", bpm, high)
+	}
+	if low := envInt("OURA_HR_ALERT_LOW", 0); low > 0 && bpm < low {
+		return fmt.Sprintf("Heart rate %d BPM is below %d", bpm, low)
+	}
+	return ""
+}
+
+func alertPath() string { return statePath(alertFileName) }
+
+// maybeAlert fires a desktop notification when e is outside the alert band,
+// at most once per cooldown. The last alert time is the mtime of a file in
+// the cache dir, so the cooldown holds across invocations.
+func maybeAlert(e hrEntry) {
+	msg := alertMessage(e.BPM)
+	if msg == "" {
+		return
+	}
+	path := alertPath()
+	if info, err := os.Stat(path); err == nil && time.Since(info.ModTime()) < alertCooldown() {
+		debugf("alert suppressed by cooldown: %s", msg)
+		return
+	}
+	if err := notify("Oura heart rate", msg); err != nil {
+		debugf("sending notification: %v", err)
+		return
+	}
+	debugf("alert sent: %s", msg)
+	if err := ensureCacheDir(); err != nil {
+		debugf("not recording alert: %v", err)
+	} else if err := os.WriteFile(path, []byte(time.Now().Format(time.RFC3339)), 0o600); err != nil {
+		debugf("recording alert: %v", err)
+	}
+}
+
+// notify starts the platform's notifier and reaps it in the background,
+// so watch mode doesn't collect a zombie per alert.
+func notify(title, msg string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		script := fmt.Sprintf("display notification %q with title %q", msg, title)
+		cmd = exec.Command("osascript", "-e", script)
+	case "linux", "freebsd", "openbsd", "netbsd":
+		cmd = exec.Command("notify-send", title, msg)
+	default:
+		return fmt.Errorf("desktop notifications are not supported on %s", runtime.GOOS)
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	go func() {
+		if err := cmd.Wait(); err != nil {
+			debugf("%s failed: %v", cmd.Args[0], err)
+		}
+	}()
+	return nil
+}
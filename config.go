@@ -0,0 +1,114 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// fileSettings holds the config file's values, keyed by the environment
+// variable each one stands in for.
+var fileSettings map[string]string
+
+// configPath is $OURA_CONFIG, or config.toml in $XDG_CONFIG_HOME/oura-hr
+// (~/.config/oura-hr if unset), or "" if neither that nor $HOME is set.
+func configPath() string {
+	if p := os.Getenv("OURA_CONFIG"); p != "" {
+		return p
+	}
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			debugf("no config file: %v", err)
+			return ""
+		}
+		dir = filepath.Join(home, ".config")
+	}
+	return filepath.Join(dir, "oura-hr", "config.toml")
+}
+
+// settingKey is the config file key for an environment variable: the name
+// without its OURA_HR_ or OURA_ prefix, lowercased, so OURA_CLIENT_ID is
+// client_id and OURA_HR_CACHE_TTL is cache_ttl.
+func settingKey(name string) string {
+	key, ok := strings.CutPrefix(name, "OURA_HR_")
+	if !ok {
+		key = strings.TrimPrefix(name, "OURA_")
+	}
+	return strings.ToLower(key)
+}
+
+// loadConfig reads the config file. A missing file is only an error if it
+// was named with OURA_CONFIG.
+func loadConfig() error {
+	path := configPath()
+	if path == "" {
+		return nil
+	}
+	var raw map[string]any
+	if _, err := toml.DecodeFile(path, &raw); err != nil {
+		if errors.Is(err, os.ErrNotExist) && os.Getenv("OURA_CONFIG") == "" {
+			return nil
+		}
+		return fmt.Errorf("reading config: %w", err)
+	}
+	debugf("loaded config from %s", path)
+	fileSettings = make(map[string]string, len(raw))
+	for k, v := range raw {
+		fileSettings[k] = configValue(v)
+	}
+	return nil
+}
+
+// configValue is v as an environment variable would hold it. Booleans
+// become "1" or "", as the switches compare against "1".
+func configValue(v any) string {
+	if b, ok := v.(bool); ok {
+		if b {
+			return "1"
+		}
+		return ""
+	}
+	return fmt.Sprint(v)
+}
+
+// secretSettings can also be read from the file named by <name>_FILE, as
+// with Docker secrets, to keep them out of the environment.
+var secretSettings = map[string]bool{
+	"OURA_CLIENT_ID":        true,
+	"OURA_CLIENT_SECRET":    true,
+	"OURA_PAT":              true,
+	"OURA_TOKEN_PASSPHRASE": true,
+}
+
+// setting is the environment variable name if it's set and non-empty, then
+// for secrets the contents of <name>_FILE, and its value in the config file
+// otherwise.
+func setting(name string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	if path := os.Getenv(name + "_FILE"); path != "" && secretSettings[name] {
+		data, err := os.ReadFile(path)
+		if err == nil {
+			return strings.TrimSpace(string(data))
+		}
+		warnf("reading %s_FILE: %v", name, err)
+	}
+	return fileSettings[settingKey(name)]
+}
+
+// lookupSetting is like setting, but an environment variable that's set
+// to the empty string counts as set.
+func lookupSetting(name string) (string, bool) {
+	if v, ok := os.LookupEnv(name); ok {
+		return v, true
+	}
+	v, ok := fileSettings[settingKey(name)]
+	return v, ok
+}
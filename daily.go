@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	sleepEndpoint     = "sleep"
+	readinessEndpoint = "daily_readiness"
+	spo2Endpoint      = "daily_spo2"
+
+	restingGlyph   = "♡"
+	readinessGlyph = "⚡"
+	sleepGlyph     = "😴"
+	spo2Glyph      = "🫁"
+	tempGlyph      = "🌡"
+)
+
+// sleepPeriod is one sleep session. Oura reports the lowest heart rate
+// during sleep as the resting heart rate.
+type sleepPeriod struct {
+	Day                string  `json:"day"`
+	Type               string  `json:"type"`
+	LowestHeartRate    int     `json:"lowest_heart_rate"`
+	AverageHeartRate   float64 `json:"average_heart_rate"`
+	TotalSleepDuration int     `json:"total_sleep_duration"` // seconds
+	Efficiency         int     `json:"efficiency"`
+}
+
+type dailyReadiness struct {
+	Day   string `json:"day"`
+	Score int    `json:"score"`
+	// Deviation from the long-term baseline in °C, or null before it's
+	// computed
+	TemperatureDeviation *float64 `json:"temperature_deviation"`
+}
+
+// dailySpO2 is the average blood oxygen overnight. Nights without a
+// measurement have no spo2_percentage.
+type dailySpO2 struct {
+	Day            string `json:"day"`
+	SpO2Percentage *struct {
+		Average float64 `json:"average"`
+	} `json:"spo2_percentage"`
+}
+
+// dayRange queries the last days days, including today.
+func dayRange(days int) url.Values {
+	today := time.Now()
+	return url.Values{
+		"start_date": {today.AddDate(0, 0, -days).Format(time.DateOnly)},
+		"end_date":   {today.AddDate(0, 0, 1).Format(time.DateOnly)},
+	}
+}
+
+func restingCommand(args []string) {
+	fs := flag.NewFlagSet("resting", flag.ExitOnError)
+	addProfileFlag(fs)
+	fs.Parse(args)
+
+	exitOnError(runCached(context.Background(), os.Stdout, "resting", func(ctx context.Context, client *apiClient) (string, error) {
+		periods, err := fetchAll[sleepPeriod](ctx, client, sleepEndpoint, dayRange(2))
+		if err != nil {
+			return "", err
+		}
+		debugf("got %d sleep periods", len(periods))
+
+		var latest *sleepPeriod
+		for i, p := range periods {
+			if p.LowestHeartRate > 0 && (latest == nil || p.Day >= latest.Day) {
+				latest = &periods[i]
+			}
+		}
+		if latest == nil {
+			return "", errNoData
+		}
+		return fmt.Sprintf("%s %d\n", restingGlyph, latest.LowestHeartRate), nil
+	}))
+}
+
+func readinessCommand(args []string) {
+	fs := flag.NewFlagSet("readiness", flag.ExitOnError)
+	addProfileFlag(fs)
+	fs.Parse(args)
+
+	exitOnError(runCached(context.Background(), os.Stdout, "readiness", func(ctx context.Context, client *apiClient) (string, error) {
+		days, err := fetchAll[dailyReadiness](ctx, client, readinessEndpoint, dayRange(2))
+		if err != nil {
+			return "", err
+		}
+		debugf("got %d readiness days", len(days))
+
+		var latest *dailyReadiness
+		for i, d := range days {
+			if d.Score > 0 && (latest == nil || d.Day >= latest.Day) {
+				latest = &days[i]
+			}
+		}
+		if latest == nil {
+			return "", errNoData
+		}
+		return fmt.Sprintf("%s %d\n", readinessGlyph, latest.Score), nil
+	}))
+}
+
+func sleepCommand(args []string) {
+	fs := flag.NewFlagSet("sleep", flag.ExitOnError)
+	addProfileFlag(fs)
+	fs.Parse(args)
+
+	exitOnError(runCached(context.Background(), os.Stdout, "sleep", func(ctx context.Context, client *apiClient) (string, error) {
+		periods, err := fetchAll[sleepPeriod](ctx, client, sleepEndpoint, dayRange(2))
+		if err != nil {
+			return "", err
+		}
+		debugf("got %d sleep periods", len(periods))
+
+		// Naps and rest periods are reported too; only the main sleep counts
+		var latest *sleepPeriod
+		for i, p := range periods {
+			if p.Type == "long_sleep" && p.TotalSleepDuration > 0 && (latest == nil || p.Day >= latest.Day) {
+				latest = &periods[i]
+			}
+		}
+		if latest == nil {
+			return "", errNoData
+		}
+
+		total := humanizeAge(time.Duration(latest.TotalSleepDuration) * time.Second)
+		if latest.AverageHeartRate > 0 {
+			return fmt.Sprintf("%s %s (eff %d%%, %s %.0f)\n", sleepGlyph, total, latest.Efficiency, defaultGlyph, latest.AverageHeartRate), nil
+		}
+		return fmt.Sprintf("%s %s (eff %d%%)\n", sleepGlyph, total, latest.Efficiency), nil
+	}))
+}
+
+func spo2Command(args []string) {
+	fs := flag.NewFlagSet("spo2", flag.ExitOnError)
+	addProfileFlag(fs)
+	fs.Parse(args)
+
+	exitOnError(runCached(context.Background(), os.Stdout, "spo2", func(ctx context.Context, client *apiClient) (string, error) {
+		days, err := fetchAll[dailySpO2](ctx, client, spo2Endpoint, dayRange(2))
+		if err != nil {
+			return "", err
+		}
+		debugf("got %d SpO2 days", len(days))
+
+		var latest *dailySpO2
+		for i, d := range days {
+			if d.SpO2Percentage != nil && d.SpO2Percentage.Average > 0 && (latest == nil || d.Day >= latest.Day) {
+				latest = &days[i]
+			}
+		}
+		if latest == nil {
+			return "", errNoData
+		}
+		return fmt.Sprintf("%s %.0f%%\n", spo2Glyph, latest.SpO2Percentage.Average), nil
+	}))
+}
+
+// tempUnit is OURA_TEMP_UNIT, C (the default) or F.
+func tempUnit() string {
+	if strings.EqualFold(setting("OURA_TEMP_UNIT"), "F") {
+		return "F"
+	}
+	return "C"
+}
+
+func tempCommand(args []string) {
+	fs := flag.NewFlagSet("temp", flag.ExitOnError)
+	addProfileFlag(fs)
+	fs.Parse(args)
+
+	unit := tempUnit()
+	exitOnError(runCached(context.Background(), os.Stdout, "temp+"+unit, func(ctx context.Context, client *apiClient) (string, error) {
+		days, err := fetchAll[dailyReadiness](ctx, client, readinessEndpoint, dayRange(2))
+		if err != nil {
+			return "", err
+		}
+		debugf("got %d readiness days", len(days))
+
+		var latest *dailyReadiness
+		for i, d := range days {
+			if d.TemperatureDeviation != nil && (latest == nil || d.Day >= latest.Day) {
+				latest = &days[i]
+			}
+		}
+		if latest == nil {
+			return "", errNoData
+		}
+		dev := *latest.TemperatureDeviation
+		if unit == "F" {
+			dev *= 9.0 / 5 // a difference, so no offset
+		}
+		return fmt.Sprintf("%s %+.1f°%s\n", tempGlyph, dev, unit), nil
+	}))
+}
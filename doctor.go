@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"time"
+)
+
+// check is one line of the doctor checklist. A failed critical check makes
+// doctor exit non-zero.
+type check struct {
+	name     string
+	ok       bool
+	critical bool
+	detail   string
+	hint     string
+}
+
+// doctorCommand checks the setup end to end and prints a checklist with
+// hints for anything that's wrong.
+func doctorCommand(args []string) {
+	fs := flag.NewFlagSet("doctor", flag.ExitOnError)
+	addProfileFlag(fs)
+	fs.Parse(args)
+
+	checks := []check{credentialsCheck(), secretCheck()}
+	checks = append(checks, tokenChecks()...)
+	checks = append(checks, cacheDirCheck(), networkCheck(), browserCheck())
+
+	failed := false
+	for _, c := range checks {
+		mark := "✓"
+		if !c.ok {
+			mark = "✗"
+			failed = failed || c.critical
+		}
+		fmt.Printf("%s %s: %s\n", mark, c.name, c.detail)
+		if !c.ok && c.hint != "" {
+			fmt.Printf("    %s\n", c.hint)
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+func credentialsCheck() check {
+	c := check{name: "Credentials", critical: true}
+	switch {
+	case setting("OURA_PAT") != "":
+		c.ok, c.detail = true, "OURA_PAT is set"
+	case setting("OURA_CLIENT_ID") != "":
+		c.ok, c.detail = true, "OURA_CLIENT_ID is set"
+	default:
+		c.detail = "neither OURA_CLIENT_ID nor OURA_PAT is set"
+		c.hint = "Set OURA_CLIENT_ID from your app at https://cloud.ouraring.com/oauth/applications, or OURA_PAT."
+	}
+	return c
+}
+
+func secretCheck() check {
+	if setting("OURA_CLIENT_SECRET") != "" {
+		return check{name: "Client secret", ok: true, detail: "OURA_CLIENT_SECRET is set"}
+	}
+	return check{name: "Client secret", ok: true, detail: "not set, which is fine for public clients using PKCE"}
+}
+
+func tokenChecks() []check {
+	if setting("OURA_PAT") != "" {
+		return []check{{name: "Tokens", ok: true, detail: "not needed with OURA_PAT"}}
+	}
+	store := tokens()
+	t, err := store.load()
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return []check{{name: "Tokens", critical: true, detail: "missing from " + store.location(), hint: "Run `oura-hr setup` to authorize."}}
+	case err != nil:
+		return []check{{name: "Tokens", critical: true, detail: err.Error(), hint: "Run `oura-hr setup` to authorize again."}}
+	}
+
+	expiry := check{name: "Token expiry", ok: true, critical: true}
+	switch d := time.Until(t.ExpiresAt); {
+	case d > 0:
+		expiry.detail = "access token expires in " + humanizeAge(d)
+	case t.RefreshToken != "":
+		expiry.detail = fmt.Sprintf("access token expired %s ago, it will be refreshed on the next run", humanizeAge(-d))
+	default:
+		expiry.ok = false
+		expiry.detail = "access token expired and there's no refresh token"
+		expiry.hint = "Run `oura-hr setup` to authorize again."
+	}
+	return []check{{name: "Tokens", ok: true, detail: store.location()}, expiry}
+}
+
+func cacheDirCheck() check {
+	c := check{name: "Cache directory", critical: true, detail: cacheDir()}
+	err := ensureCacheDir()
+	if err == nil {
+		var f *os.File
+		if f, err = os.CreateTemp(cacheDir(), ".oura-hr-doctor*"); err == nil {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}
+	if err != nil {
+		c.detail = err.Error()
+		c.hint = "Make it writable, or point OURA_HR_DIR somewhere that is."
+		return c
+	}
+	c.ok = true
+	return c
+}
+
+// networkCheck only needs the API to answer at all; without a token that's
+// a 401.
+func networkCheck() check {
+	c := check{name: "Oura API", critical: true}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout())
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "HEAD", apiEndpoint(heartRateEndpoint), nil)
+	if err != nil {
+		c.detail = err.Error()
+		return c
+	}
+	resp, err := httpClient().Do(req)
+	if err != nil {
+		c.detail = err.Error()
+		c.hint = "Check your connection, and HTTPS_PROXY if you're behind a proxy."
+		return c
+	}
+	resp.Body.Close()
+	c.ok, c.detail = true, fmt.Sprintf("%s answered %s", req.URL.Host, resp.Status)
+	return c
+}
+
+func browserCheck() check {
+	c := check{name: "Browser for setup"}
+	for _, cmd := range browserCommands("") {
+		if path, err := exec.LookPath(cmd.Args[0]); err == nil {
+			c.ok, c.detail = true, path
+			return c
+		}
+	}
+	c.detail = "no launcher found"
+	c.hint = "Set BROWSER, or run `oura-hr setup --manual` and open the URL yourself."
+	return c
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"slices"
+	"time"
+)
+
+const (
+	etagFileName = "oura-hr-etags.json"
+	maxETags     = 32
+)
+
+// etagEntry is a response body kept for revalidation with If-None-Match.
+type etagEntry struct {
+	ETag string    `json:"etag"`
+	Body string    `json:"body"`
+	Used time.Time `json:"used"`
+}
+
+func etagPath() string { return statePath(etagFileName) }
+
+// loadETags returns the stored responses by URL. A missing or unreadable
+// file just means nothing can be revalidated.
+func loadETags() map[string]etagEntry {
+	etags := map[string]etagEntry{}
+	if data, err := os.ReadFile(etagPath()); err == nil {
+		json.Unmarshal(data, &etags)
+	}
+	return etags
+}
+
+// rememberETag stores body under reqURL, dropping the least recently used
+// entries beyond maxETags so the file can't grow without bound.
+func rememberETag(reqURL, etag string, body []byte) {
+	etags := loadETags()
+	etags[reqURL] = etagEntry{ETag: etag, Body: string(body), Used: time.Now()}
+	if len(etags) > maxETags {
+		urls := make([]string, 0, len(etags))
+		for u := range etags {
+			urls = append(urls, u)
+		}
+		slices.SortFunc(urls, func(a, b string) int {
+			return etags[b].Used.Compare(etags[a].Used)
+		})
+		for _, u := range urls[maxETags:] {
+			delete(etags, u)
+		}
+	}
+
+	data, _ := json.Marshal(etags)
+	if err := ensureCacheDir(); err != nil {
+		debugf("not saving ETag: %v", err)
+	} else if err := writeFileAtomic(etagPath(), data, 0o600); err != nil {
+		debugf("saving ETag: %v", err)
+	}
+}
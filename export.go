@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+)
+
+// exportCommand writes the readings in a range as CSV, one page at a time.
+func exportCommand(args []string) {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	since := fs.String("since", "", "export readings from this time (RFC3339 or YYYY-MM-DD)")
+	until := fs.String("until", "", "export readings up to this time; defaults to now")
+	out := fs.String("out", "-", "CSV file to write, or - for stdout")
+	addProfileFlag(fs)
+	fs.Parse(args)
+
+	if err := export(context.Background(), *since, *until, *out); err != nil {
+		fmt.Fprintf(os.Stderr, "Export failed: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func export(ctx context.Context, since, until, out string) error {
+	start, end, err := parseRange(since, until)
+	if err != nil {
+		return err
+	}
+	client, err := newClient()
+	if err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+	if out != "-" {
+		f, err := os.Create(out)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"timestamp", "bpm", "source"})
+	n := 0
+	err = eachPage(ctx, client, heartRateEndpoint, heartRateQuery(start, end), func(entries []hrEntry) error {
+		for _, e := range entries {
+			cw.Write([]string{e.Timestamp, strconv.Itoa(e.BPM), e.Source})
+		}
+		n += len(entries)
+		cw.Flush()
+		return cw.Error()
+	})
+	if err != nil {
+		return err
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		return err
+	}
+	debugf("exported %d entries", n)
+	return nil
+}
@@ -1,3 +1,22 @@
 module github.com/nengberg/oura-hr
 
 go 1.22
+
+require (
+	github.com/BurntSushi/toml v1.4.0
+	github.com/prometheus/client_golang v1.19.1
+	github.com/zalando/go-keyring v0.2.8
+	golang.org/x/crypto v0.29.0
+	golang.org/x/sys v0.27.0
+)
+
+require (
+	github.com/beorn7/perks v1.0.1 // indirect
+	github.com/cespare/xxhash/v2 v2.2.0 // indirect
+	github.com/danieljoos/wincred v1.2.3 // indirect
+	github.com/godbus/dbus/v5 v5.2.2 // indirect
+	github.com/prometheus/client_model v0.5.0 // indirect
+	github.com/prometheus/common v0.48.0 // indirect
+	github.com/prometheus/procfs v0.12.0 // indirect
+	google.golang.org/protobuf v1.33.0 // indirect
+)
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+)
+
+const (
+	historyFileName      = "oura-hr-history.json"
+	defaultHistoryLength = 20
+)
+
+var sparkBars = []rune("▁▂▃▄▅▆▇█")
+
+func historyPath() string { return statePath(historyFileName) }
+
+func historyLength() int {
+	if v := setting("OURA_HR_HISTORY"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			return n
+		}
+	}
+	return defaultHistoryLength
+}
+
+func loadHistory() []hrEntry {
+	data, err := os.ReadFile(historyPath())
+	if err != nil {
+		return nil
+	}
+	var h []hrEntry
+	json.Unmarshal(data, &h)
+	return h
+}
+
+// appendHistory records e unless it's already the newest entry, trims the
+// history to historyLength and returns it. The update holds the history's
+// lock, so concurrent runs can't drop each other's readings.
+func appendHistory(e hrEntry) []hrEntry {
+	unlock := lockCache(historyPath(), true)
+	defer unlock()
+	h := loadHistory()
+	if len(h) == 0 || h[len(h)-1].Timestamp != e.Timestamp {
+		h = append(h, e)
+	}
+	if n := historyLength(); len(h) > n {
+		h = h[len(h)-n:]
+	}
+	data, _ := json.Marshal(h)
+	if err := ensureCacheDir(); err != nil {
+		debugf("not saving history: %v", err)
+	} else if err := writeFileAtomic(historyPath(), data, 0o600); err != nil {
+		debugf("saving history: %v", err)
+	}
+	return h
+}
+
+// writeFileAtomic writes to a temp file in the same directory and renames
+// it into place, so concurrent readers never see a partial file.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(f.Name(), perm); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+func sparkline(values []int) string {
+	if len(values) == 0 {
+		return ""
+	}
+	lo, hi := slices.Min(values), slices.Max(values)
+	bars := make([]rune, len(values))
+	for i, v := range values {
+		if hi == lo {
+			bars[i] = sparkBars[0]
+			continue
+		}
+		bars[i] = sparkBars[(v-lo)*(len(sparkBars)-1)/(hi-lo)]
+	}
+	return string(bars)
+}
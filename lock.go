@@ -0,0 +1,24 @@
+package main
+
+import "os"
+
+// lockFile opens path and takes an advisory lock on it, shared or
+// exclusive, blocking until it's available. The lock is released by the
+// returned unlock func, or by the OS if the process dies.
+func lockFile(path string, exclusive bool) (unlock func(), err error) {
+	if err := ensureCacheDir(); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	if err := lockHandle(f, exclusive); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return func() {
+		unlockHandle(f)
+		f.Close()
+	}, nil
+}
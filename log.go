@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// maxLogSize is the size at which the OURA_HR_LOG file is rotated to
+// <path>.1, replacing the previous one.
+const maxLogSize = 1 << 20
+
+// logPoll appends a timestamped line to the OURA_HR_LOG file, if it's set,
+// to piece together after the fact why a bar went blank. Failing to write
+// it never affects the output, so that's only logged with --verbose.
+func logPoll(format string, args ...any) {
+	path := setting("OURA_HR_LOG")
+	if path == "" {
+		return
+	}
+	if err := appendLog(path, fmt.Sprintf(format, args...)); err != nil {
+		debugf("writing %s: %v", path, err)
+	}
+}
+
+func appendLog(path, msg string) error {
+	// Invocations from the bar and a timer can overlap
+	unlock, err := lockFile(path+".lock", true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if info, err := os.Stat(path); err == nil && info.Size() >= maxLogSize {
+		if err := os.Rename(path, path+".1"); err != nil {
+			return err
+		}
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(f, "%s %s\n", time.Now().Format(time.RFC3339), msg); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
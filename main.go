@@ -1,29 +1,61 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
+	"math"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path/filepath"
+	"runtime"
+	"slices"
 	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"text/template"
 	"time"
 )
 
 const (
-	apiURL      = "https://api.ouraring.com/v2/usercollection/heartrate"
-	tokenURL    = "https://api.ouraring.com/oauth/token"
-	authURL     = "https://cloud.ouraring.com/oauth/authorize"
-	redirectURI = "http://localhost:8085/callback"
-	scope       = "heartrate"
-
-	defaultTTL    = 300
-	cacheFileName = "oura-hr"
-	tokenFileName = "oura-tokens.json"
+	apiURL            = "https://api.ouraring.com/v2/usercollection"
+	sandboxURL        = "https://api.ouraring.com/v2/sandbox/usercollection"
+	tokenURL          = "https://api.ouraring.com/oauth/token"
+	authURL           = "https://cloud.ouraring.com/oauth/authorize"
+	heartRateEndpoint = "heartrate"
+	defaultScopes     = "heartrate"
+
+	defaultTTL          = 300
+	defaultStaleTTL     = 3600
+	defaultWindow       = 4 * time.Hour
+	defaultTimeout      = 8 * time.Second
+	rateLimitRetries    = 3
+	defaultRetries      = 2
+	retryBackoff        = 500 * time.Millisecond
+	maxRetryAfter       = 30 * time.Second
+	defaultExpiresIn    = time.Hour
+	defaultMaxResponse  = 16 << 20 // bytes
+	maxExpiresIn        = 30 * 24 * time.Hour
+	defaultRedirectPort = 8085
+	defaultGlyph        = "♥"
+	defaultElevated     = 100
+	defaultZoneLow      = 80
+	defaultZoneHigh     = 100
+	cacheFileName       = "oura-hr"
+	tokenFileName       = "oura-tokens.json"
 )
 
 type storedTokens struct {
@@ -38,72 +70,745 @@ type hrEntry struct {
 	Timestamp string `json:"timestamp"`
 }
 
-type hrResponse struct {
-	Data []hrEntry `json:"data"`
+var (
+	jsonFlag          = flag.Bool("json", false, "print the latest reading as a JSON object (prints nothing when there is no data)")
+	plainFlag         = flag.Bool("plain", false, "print just the BPM with no glyph and no trailing newline")
+	trendFlag         = flag.Bool("trend", false, "append an arrow showing whether BPM is rising or falling")
+	colorFlag         = flag.Bool("color", false, "color the BPM by zone in the default, plain, tmux, polybar, i3blocks and xbar output (ignored if NO_COLOR is set)")
+	zoneFlag          = flag.Bool("zone", false, "append the training zone (Z1-Z5) based on OURA_MAX_HR or OURA_AGE")
+	pctFlag           = flag.Bool("pct", false, "show the BPM as a percentage of OURA_MAX_HR (or 220 minus OURA_AGE)")
+	sparkFlag         = flag.Bool("sparkline", false, "append a sparkline of recent readings")
+	withAgeFlag       = flag.Bool("with-age", false, "append how long ago the reading was taken, e.g. ♥ 62 · 2m")
+	avgFlag           = flag.Bool("avg", false, "show the mean BPM over the window instead of the latest reading")
+	rangeFlag         = flag.Bool("range", false, "append the lowest and highest BPM over the window")
+	sinceFlag         = flag.String("since", "", "query readings from this time (RFC3339 or YYYY-MM-DD) instead of the recent window")
+	untilFlag         = flag.String("until", "", "query readings up to this time (RFC3339 or YYYY-MM-DD); defaults to now")
+	countFlag         = flag.Int("count", 1, "list the last N readings in the window, one per line (or a JSON array with --json)")
+	localFlag         = flag.Bool("local", false, "show timestamps in local time (or OURA_TZ), also in JSON")
+	verboseFlag       = flag.Bool("verbose", false, "log each step to stderr (same as OURA_HR_DEBUG=1)")
+	quietFlag         = flag.Bool("quiet", false, "do not log warnings to stderr, only errors that stop the run (same as OURA_HR_QUIET=1)")
+	formatFlag        = flag.String("format", "", "output format: json or a text/template like '{{.BPM}} bpm' (default $OURA_HR_FORMAT)")
+	profileFlag       = newProfileFlag(flag.CommandLine)
+	noCacheFlag       = flag.Bool("no-cache", false, "fetch live data even if the cached value is still fresh")
+	refreshTokensFlag = flag.Bool("refresh-tokens", false, "refresh and save the OAuth tokens without fetching, e.g. daily from cron to keep them from expiring")
+	versionFlag       = flag.Bool("version", false, "print the version and exit")
+	outFlag           = flag.String("out", "", "write the output atomically to this file instead of stdout (- for stdout)")
+)
+
+// userCacheDir is OURA_HR_DIR, then $XDG_CACHE_HOME, then ~/.cache.
+// OURA_HR_DIR moves just oura-hr's files, without affecting other programs.
+func userCacheDir() (string, error) {
+	if dir := setting("OURA_HR_DIR"); dir != "" {
+		return dir, nil
+	}
+	if dir := os.Getenv("XDG_CACHE_HOME"); dir != "" {
+		return dir, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("can't determine the cache directory, set OURA_HR_DIR: %w", err)
+	}
+	return filepath.Join(home, ".cache"), nil
 }
 
+// cacheDir is userCacheDir, or "" if it can't be determined. Writers call
+// ensureCacheDir first, and readers checkCacheDir, which report that, so
+// nothing is silently read from the current directory instead.
 func cacheDir() string {
-	if dir := os.Getenv("XDG_CACHE_HOME"); dir != "" {
-		return dir
+	dir, _ := userCacheDir()
+	return dir
+}
+
+// checkCacheDir reports userCacheDir's error as a broken setup.
+func checkCacheDir() error {
+	if _, err := userCacheDir(); err != nil {
+		return storeError{err}
+	}
+	return nil
+}
+
+// ensureCacheDir creates the cache directory if needed.
+func ensureCacheDir() error {
+	dir, err := userCacheDir()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("creating cache directory: %w", err)
 	}
-	home, _ := os.UserHomeDir()
-	return filepath.Join(home, ".cache")
+	return nil
 }
 
-func cachePath() string { return filepath.Join(cacheDir(), cacheFileName) }
-func tokenPath() string { return filepath.Join(cacheDir(), tokenFileName) }
+const profileUsage = "use the tokens and cache of profile `name`, for multiple accounts (default $OURA_PROFILE)"
 
+// filePath is the file named by the setting name, or the profile's def.
+// Relative names are taken relative to the cache directory. An explicit
+// name is used as is, without the profile suffix.
+func filePath(name, def string) string {
+	v := setting(name)
+	switch {
+	case v == "":
+		return filepath.Join(cacheDir(), profileName(def))
+	case filepath.IsAbs(v):
+		return v
+	}
+	return filepath.Join(cacheDir(), v)
+}
+
+func tokenPath() string { return filePath("OURA_TOKEN_FILE", tokenFileName) }
+
+// cacheBase is the cache file of the default output; other outputs are
+// cached next to it.
+func cacheBase() string { return filePath("OURA_CACHE_FILE", cacheFileName) }
+
+// statePath is where the state file name is kept: in the cache directory
+// with the profile suffix, or with OURA_CACHE_FILE next to that cache and
+// named after it, so a second instance never shares another's state.
+func statePath(name string) string {
+	if setting("OURA_CACHE_FILE") == "" {
+		return filepath.Join(cacheDir(), profileName(name))
+	}
+	return cacheBase() + strings.TrimPrefix(name, cacheFileName)
+}
+
+// cachePath keys the cache on the output format so switching formats never
+// serves output rendered by another one.
+func cachePath(format string) string {
+	if format == "" {
+		return cacheBase()
+	}
+	sum := sha256.Sum256([]byte(format))
+	return fmt.Sprintf("%s-%x", cacheBase(), sum[:4])
+}
+
+func redirectPort() int { return envInt("OURA_REDIRECT_PORT", defaultRedirectPort) }
+
+// scopes is the space-separated list of OAuth scopes requested during setup.
+func scopes() string {
+	if v := strings.Fields(setting("OURA_SCOPES")); len(v) > 0 {
+		return strings.Join(v, " ")
+	}
+	return defaultScopes
+}
+
+func redirectURI() string {
+	return fmt.Sprintf("http://localhost:%d/callback", redirectPort())
+}
+
+// ttl is how long, in seconds, cached output is served without fetching.
+// 0 disables the cache; negative values are ignored with a warning.
 func ttl() int {
-	if v := os.Getenv("OURA_HR_CACHE_TTL"); v != "" {
+	n := envInt("OURA_HR_CACHE_TTL", defaultTTL)
+	if n < 0 {
+		warnf("ignoring negative OURA_HR_CACHE_TTL %d, using %d", n, defaultTTL)
+		return defaultTTL
+	}
+	return n
+}
+
+// staleTTL is how long, in seconds, a cached value may still be shown when
+// fetching fresh data fails.
+func staleTTL() int { return envInt("OURA_HR_STALE_TTL", defaultStaleTTL) }
+
+func verbose() bool {
+	return *verboseFlag || setting("OURA_HR_DEBUG") == "1"
+}
+
+// debugf logs to stderr in verbose mode; stdout stays reserved for output.
+func debugf(format string, args ...any) {
+	if verbose() {
+		fmt.Fprintf(os.Stderr, "oura-hr: "+format+"\n", args...)
+	}
+}
+
+func quiet() bool {
+	return *quietFlag || setting("OURA_HR_QUIET") == "1"
+}
+
+// warnf logs a problem that doesn't stop the run to stderr, unless in
+// quiet mode. Errors that do are printed regardless.
+func warnf(format string, args ...any) {
+	if !quiet() {
+		fmt.Fprintf(os.Stderr, "oura-hr: "+format+"\n", args...)
+	}
+}
+
+// fontGlyphs are the glyph presets selectable with OURA_HR_FONT.
+var fontGlyphs = map[string]string{
+	"nerd":  "\uf21e", // nf-fa-heartbeat
+	"emoji": defaultGlyph,
+	"ascii": "HR:",
+}
+
+// glyph is OURA_HR_GLYPH if set, or else the OURA_HR_FONT preset. Without
+// either the preset is emoji for UTF-8 locales and ascii otherwise.
+func glyph() string {
+	if v, ok := lookupSetting("OURA_HR_GLYPH"); ok {
+		return v
+	}
+	if g, ok := fontGlyphs[setting("OURA_HR_FONT")]; ok {
+		return g
+	}
+	if !utf8Locale() {
+		return fontGlyphs["ascii"]
+	}
+	return defaultGlyph
+}
+
+// utf8Locale reports whether the locale, as set by the first of LC_ALL,
+// LC_CTYPE and LANG that's set, uses UTF-8. With none set the terminal is
+// assumed to cope, unless TERM says it's the Linux or a dumb console.
+func utf8Locale() bool {
+	for _, name := range []string{"LC_ALL", "LC_CTYPE", "LANG"} {
+		if v := os.Getenv(name); v != "" {
+			v = strings.ToLower(v)
+			return strings.Contains(v, "utf-8") || strings.Contains(v, "utf8")
+		}
+	}
+	term := os.Getenv("TERM")
+	return term != "linux" && term != "dumb"
+}
+
+// elevatedBPM is the threshold above which waybar output gets the
+// "elevated" class.
+func elevatedBPM() int { return envInt("OURA_HR_ELEVATED", defaultElevated) }
+
+func envOr(name, def string) string {
+	if v := setting(name); v != "" {
+		return v
+	}
+	return def
+}
+
+func envInt(name string, def int) int {
+	if v := setting(name); v != "" {
 		if n, err := strconv.Atoi(v); err == nil {
 			return n
 		}
 	}
-	return defaultTTL
+	return def
 }
 
-func loadTokens() (*storedTokens, error) {
-	data, err := os.ReadFile(tokenPath())
+// zoneColor returns the color for bpm: green below OURA_HR_ZONE_LOW, red
+// above OURA_HR_ZONE_HIGH and yellow in between.
+func zoneColor(bpm int) string {
+	switch {
+	case bpm < envInt("OURA_HR_ZONE_LOW", defaultZoneLow):
+		return "green"
+	case bpm > envInt("OURA_HR_ZONE_HIGH", defaultZoneHigh):
+		return "red"
+	}
+	return "yellow"
+}
+
+var ansiColors = map[string]string{"green": "\033[32m", "yellow": "\033[33m", "red": "\033[31m"}
+
+// hexColors are for bars that take #rrggbb colors.
+var hexColors = map[string]string{"green": "#50fa7b", "yellow": "#f1fa8c", "red": "#ff5555"}
+
+// polybarRamp reads OURA_HR_POLYBAR_RAMP, three comma-separated glyphs
+// shown instead of the glyph in the green, yellow and red zones. It's nil
+// if unset or not three glyphs.
+func polybarRamp() []string {
+	ramp := strings.Split(setting("OURA_HR_POLYBAR_RAMP"), ",")
+	if len(ramp) != 3 {
+		return nil
+	}
+	return ramp
+}
+
+// colorEnabled honors NO_COLOR (https://no-color.org) over --color.
+func colorEnabled() bool {
+	return *colorFlag && os.Getenv("NO_COLOR") == ""
+}
+
+func outputFormat() string {
+	switch {
+	case *jsonFlag:
+		return "json"
+	case *plainFlag:
+		return "plain"
+	case *formatFlag != "":
+		return *formatFlag
+	case setting("OURA_HR_PLAIN") == "1":
+		return "plain"
+	}
+	return setting("OURA_HR_FORMAT")
+}
+
+func aggregateMode() string {
+	if *avgFlag {
+		return "avg"
+	}
+	return setting("OURA_HR_AGGREGATE")
+}
+
+// pickEntry selects the reading to show according to OURA_HR_PICK:
+// latest (the default) or earliest by timestamp, or highest or lowest by
+// BPM with ties going to the newer reading. Like latestEntry it skips
+// entries with unparseable timestamps.
+func pickEntry(entries []hrEntry) hrEntry {
+	pick := setting("OURA_HR_PICK")
+	if pick != "earliest" && pick != "highest" && pick != "lowest" {
+		return latestEntry(entries)
+	}
+	var best hrEntry
+	var bestAt time.Time
+	for _, e := range entries {
+		ts := entryTime(e)
+		if ts.IsZero() {
+			continue
+		}
+		better := bestAt.IsZero()
+		switch {
+		case better:
+		case pick == "earliest":
+			better = ts.Before(bestAt)
+		case e.BPM == best.BPM:
+			better = ts.After(bestAt)
+		case pick == "highest":
+			better = e.BPM > best.BPM
+		default:
+			better = e.BPM < best.BPM
+		}
+		if better {
+			best, bestAt = e, ts
+		}
+	}
+	if bestAt.IsZero() {
+		return latestEntry(entries)
+	}
+	return best
+}
+
+// aggregate returns the picked reading with its BPM replaced according to
+// mode; an empty or unknown mode leaves it as is.
+func aggregate(entries []hrEntry, mode string) hrEntry {
+	e := pickEntry(entries)
+	switch mode {
+	case "avg":
+		e.BPM = averageBPM(entries)
+	}
+	return e
+}
+
+// bpmRange is the lowest and highest BPM in entries.
+func bpmRange(entries []hrEntry) (lo, hi int) {
+	lo, hi = entries[0].BPM, entries[0].BPM
+	for _, e := range entries[1:] {
+		lo, hi = min(lo, e.BPM), max(hi, e.BPM)
+	}
+	return lo, hi
+}
+
+func averageBPM(entries []hrEntry) int {
+	sum := 0
+	for _, e := range entries {
+		sum += e.BPM
+	}
+	return int(math.Round(float64(sum) / float64(len(entries))))
+}
+
+// reading is what the formatters render: the chosen entry plus extras
+// derived from the rest of the window.
+type reading struct {
+	hrEntry
+	Trend     string `json:"trend,omitempty"`
+	Sparkline string `json:"sparkline,omitempty"`
+	Zone      int    `json:"zone,omitempty"`
+	Pct       int    `json:"pct_max,omitempty"` // of max HR, with --pct
+	Min       int    `json:"min,omitempty"`     // over the window, with --range
+	Max       int    `json:"max,omitempty"`
+	Stale     bool   `json:"stale,omitempty"`
+	Age       string `json:"age,omitempty"` // e.g. 2m, with --with-age
+
+	color string // zoneColor of the BPM, if coloring is enabled
+}
+
+// value is the BPM (or with --pct its percentage of max HR) with any
+// enabled annotations, e.g. "62↑ (↓58 ↑71) Z2 ▃▅▇".
+func (r reading) value() string {
+	v := strconv.Itoa(r.BPM)
+	if r.Pct != 0 {
+		v = strconv.Itoa(r.Pct) + "%"
+	}
+	if r.color != "" {
+		v = ansiColors[r.color] + v + "\033[0m"
+	}
+	v += r.Trend
+	if r.Max != 0 {
+		v += fmt.Sprintf(" (↓%d ↑%d)", r.Min, r.Max)
+	}
+	if r.Zone != 0 {
+		v += " Z" + strconv.Itoa(r.Zone)
+	}
+	if r.Sparkline != "" {
+		v += " " + r.Sparkline
+	}
+	if r.Stale {
+		v += " (stale)"
+	}
+	if r.Age != "" {
+		v += " · " + r.Age
+	}
+	return v
+}
+
+// label is the default rendering, e.g. "♥ 62".
+func (r reading) label(glyph string) string {
+	if glyph == "" {
+		return r.value()
+	}
+	return glyph + " " + r.value()
+}
+
+// humanizeAge renders d compactly as 10s, 3m, 1h22m or 2d5h.
+func humanizeAge(d time.Duration) string {
+	d = d.Round(time.Second)
+	switch {
+	case d < time.Minute:
+		return fmt.Sprintf("%ds", int(d.Seconds()))
+	case d < time.Hour:
+		return fmt.Sprintf("%dm", int(d.Minutes()))
+	case d < 24*time.Hour:
+		if m := int(d.Minutes()) % 60; m != 0 {
+			return fmt.Sprintf("%dh%dm", int(d.Hours()), m)
+		}
+		return fmt.Sprintf("%dh", int(d.Hours()))
+	}
+	if h := int(d.Hours()) % 24; h != 0 {
+		return fmt.Sprintf("%dd%dh", int(d.Hours())/24, h)
+	}
+	return fmt.Sprintf("%dd", int(d.Hours())/24)
+}
+
+type waybarOutput struct {
+	Text    string `json:"text"`
+	Tooltip string `json:"tooltip"`
+	Class   string `json:"class"`
+}
+
+func waybar(r reading, glyph string) waybarOutput {
+	out := waybarOutput{Text: r.label(glyph), Class: "normal"}
+	if r.BPM > elevatedBPM() {
+		out.Class = "elevated"
+	}
+	if r.Stale {
+		out.Class = "stale"
+	}
+	if ts := entryTime(r.hrEntry); !ts.IsZero() {
+		out.Tooltip = fmt.Sprintf("Last reading %s ago (%s)", humanizeAge(time.Since(ts)), r.Source)
+	} else {
+		out.Tooltip = fmt.Sprintf("Last reading (%s)", r.Source)
+	}
+	return out
+}
+
+// xbar renders r as a SwiftBar/xbar plugin: the menu-bar title, a "---"
+// separator, then the dropdown items.
+func xbar(r reading, glyph string) string {
+	c := r.color
+	r.color = ""
+	var b strings.Builder
+	b.WriteString(r.label(glyph))
+	if c != "" {
+		b.WriteString(" | color=" + c)
+	}
+	b.WriteString("\n---\n")
+	fmt.Fprintf(&b, "Source: %s\n", r.Source)
+	if ts := entryTime(r.hrEntry); !ts.IsZero() {
+		fmt.Fprintf(&b, "Last reading %s ago | color=gray\n", humanizeAge(time.Since(ts)))
+		fmt.Fprintf(&b, "%s | color=gray\n", displayEntry(r.hrEntry, false).Timestamp)
+	}
+	b.WriteString("Refresh | refresh=true\n")
+	return b.String()
+}
+
+// newFormatter compiles format once; anything other than "", "plain",
+// "json", "tmux", "polybar", "i3blocks", "waybar" or "xbar" is treated as a text/template executed against the
+// reading.
+func newFormatter(format string) (func(reading) (string, error), error) {
+	switch format {
+	case "":
+		g := glyph()
+		return func(r reading) (string, error) {
+			return r.label(g) + "\n", nil
+		}, nil
+	case "plain":
+		return func(r reading) (string, error) {
+			return r.value(), nil
+		}, nil
+	case "json":
+		return func(r reading) (string, error) {
+			r.hrEntry = displayEntry(r.hrEntry, true)
+			data, err := json.Marshal(r)
+			return string(data) + "\n", err
+		}, nil
+	case "tmux":
+		// tmux status lines take no newline and their own color directives
+		g := glyph()
+		return func(r reading) (string, error) {
+			c := r.color
+			r.color = ""
+			if c == "" {
+				return r.label(g), nil
+			}
+			return "#[fg=" + c + "]" + r.label(g) + "#[default]", nil
+		}, nil
+	case "waybar":
+		g := glyph()
+		return func(r reading) (string, error) {
+			data, err := json.Marshal(waybar(r, g))
+			return string(data) + "\n", err
+		}, nil
+	case "polybar":
+		// polybar takes %{F…} color tags and no newline, like tmux
+		g, ramp := glyph(), polybarRamp()
+		return func(r reading) (string, error) {
+			gl := g
+			if ramp != nil {
+				gl = ramp[slices.Index([]string{"green", "yellow", "red"}, zoneColor(r.BPM))]
+			}
+			c := r.color
+			r.color = ""
+			if c == "" {
+				return r.label(gl), nil
+			}
+			return "%{F" + hexColors[c] + "}" + r.label(gl) + "%{F-}", nil
+		}, nil
+	case "i3blocks":
+		// full text, short text and, if colored, the color on separate lines
+		g := glyph()
+		return func(r reading) (string, error) {
+			c := r.color
+			r.color = ""
+			short := strconv.Itoa(r.BPM)
+			if r.Pct != 0 {
+				short = strconv.Itoa(r.Pct) + "%"
+			}
+			out := r.label(g) + "\n" + short + "\n"
+			if c != "" {
+				out += hexColors[c] + "\n"
+			}
+			return out, nil
+		}, nil
+	case "xbar":
+		g := glyph()
+		return func(r reading) (string, error) {
+			return xbar(r, g), nil
+		}, nil
+	}
+
+	tmpl, err := template.New("format").Parse(format)
 	if err != nil {
 		return nil, err
 	}
-	var t storedTokens
-	return &t, json.Unmarshal(data, &t)
+	return func(r reading) (string, error) {
+		r.hrEntry = displayEntry(r.hrEntry, false)
+		var b strings.Builder
+		if err := tmpl.Execute(&b, r); err != nil {
+			return "", err
+		}
+		return b.String() + "\n", nil
+	}, nil
+}
+
+func pctEnabled() bool {
+	return *pctFlag || setting("OURA_HR_PCT") == "1"
+}
+
+func rangeEnabled() bool {
+	return *rangeFlag || setting("OURA_HR_RANGE") == "1"
+}
+
+func trendEnabled() bool {
+	return *trendFlag || setting("OURA_HR_TREND") == "1"
+}
+
+// trend compares the two newest readings in the window.
+func trend(entries []hrEntry) string {
+	if len(entries) < 2 {
+		return ""
+	}
+	sorted := slices.Clone(entries)
+	slices.SortStableFunc(sorted, func(a, b hrEntry) int {
+		return entryTime(a).Compare(entryTime(b))
+	})
+	return trendArrow(sorted[len(sorted)-2].BPM, sorted[len(sorted)-1].BPM)
+}
+
+// trendArrow treats changes within ±2 BPM as steady so the arrow doesn't
+// flicker between polls.
+func trendArrow(prev, cur int) string {
+	switch d := cur - prev; {
+	case d > 2:
+		return "↑"
+	case d < -2:
+		return "↓"
+	}
+	return "→"
+}
+
+// window is how far back to query for readings. A time.Duration tops out
+// around 292 years, so any valid value still yields an RFC3339 start time.
+// It's resolved once so a bad value is only warned about once.
+var window = sync.OnceValue(func() time.Duration {
+	v := setting("OURA_HR_WINDOW")
+	if v == "" {
+		return defaultWindow
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		warnf("ignoring invalid OURA_HR_WINDOW %q, using %s", v, defaultWindow)
+		return defaultWindow
+	}
+	return d
+})
+
+// staleAfter is the age past which a reading is marked stale, or 0 to
+// never mark readings.
+func staleAfter() time.Duration {
+	if v := setting("OURA_HR_STALE_AFTER"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			return d
+		}
+	}
+	return 0
+}
+
+// isStale reports whether e is older than staleAfter. A timestamp ahead of
+// the local clock counts as brand new rather than as negative age.
+func isStale(e hrEntry) bool {
+	limit := staleAfter()
+	ts := entryTime(e)
+	if limit == 0 || ts.IsZero() {
+		return false
+	}
+	return max(0, time.Since(ts)) > limit
+}
+
+func timeout() time.Duration {
+	if v := setting("OURA_HR_TIMEOUT"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			return d
+		}
+	}
+	return defaultTimeout
+}
+
+// transport honors HTTP_PROXY, HTTPS_PROXY and NO_PROXY. It's set
+// explicitly rather than inherited so proxy support can't silently go away.
+var transport = func() *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.Proxy = http.ProxyFromEnvironment
+	return t
+}()
+
+// readBody reads a response body of up to OURA_HR_MAX_RESPONSE bytes, so
+// a broken server or proxy can't make it allocate without bound.
+func readBody(r io.Reader) ([]byte, error) {
+	limit := envInt("OURA_HR_MAX_RESPONSE", defaultMaxResponse)
+	body, err := io.ReadAll(io.LimitReader(r, int64(limit)+1))
+	if err == nil && len(body) > limit {
+		return nil, fmt.Errorf("response is larger than OURA_HR_MAX_RESPONSE (%d bytes)", limit)
+	}
+	return body, err
+}
+
+// userAgent is OURA_HR_USER_AGENT, or oura-hr/<version>.
+func userAgent() string { return envOr("OURA_HR_USER_AGENT", "oura-hr/"+version) }
+
+// userAgentTransport sets the User-Agent of every request, so they're
+// identifiable in Oura's logs.
+type userAgentTransport struct{ base http.RoundTripper }
+
+func (t userAgentTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set("User-Agent", userAgent())
+	return t.base.RoundTrip(req)
 }
 
-func saveTokens(t *storedTokens) {
-	data, _ := json.Marshal(t)
-	os.MkdirAll(cacheDir(), 0o755)
-	os.WriteFile(tokenPath(), data, 0o600)
+func httpClient() *http.Client {
+	return &http.Client{Timeout: timeout(), Transport: userAgentTransport{transport}}
 }
 
 func exchangeToken(clientID, clientSecret string, vals url.Values) (*storedTokens, error) {
 	vals.Set("client_id", clientID)
-	vals.Set("client_secret", clientSecret)
+	if clientSecret != "" { // public clients rely on PKCE instead
+		vals.Set("client_secret", clientSecret)
+	}
 
-	resp, err := http.PostForm(tokenURL, vals)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout())
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "POST", envOr("OURA_TOKEN_URL", tokenURL), strings.NewReader(vals.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := httpClient().Do(req)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
-	body, _ := io.ReadAll(resp.Body)
+	body, err := readBody(resp.Body)
+	if err != nil {
+		return nil, err
+	}
 
 	var result struct {
 		AccessToken  string `json:"access_token"`
 		RefreshToken string `json:"refresh_token"`
 		ExpiresIn    int    `json:"expires_in"`
 		Error        string `json:"error"`
+		Description  string `json:"error_description"`
 	}
 	if err := json.Unmarshal(body, &result); err != nil || result.AccessToken == "" {
+		if result.Error != "" {
+			return nil, oauthError{result.Error, result.Description}
+		}
 		return nil, fmt.Errorf("token exchange failed: %s", body)
 	}
 	return &storedTokens{
 		AccessToken:  result.AccessToken,
 		RefreshToken: result.RefreshToken,
-		ExpiresAt:    time.Now().Add(time.Duration(result.ExpiresIn) * time.Second),
+		ExpiresAt:    time.Now().Add(tokenLifetime(result.ExpiresIn)),
 	}, nil
 }
 
+// tokenLifetime turns expires_in into a duration. A missing or
+// non-positive value would make the token expire on arrival and refresh
+// on every run, so it falls back to defaultExpiresIn; absurdly long ones
+// are capped so a bad value can't pin a token forever.
+func tokenLifetime(expiresIn int) time.Duration {
+	if expiresIn <= 0 {
+		debugf("token response has no expires_in, assuming %s", defaultExpiresIn)
+		return defaultExpiresIn
+	}
+	if d := time.Duration(expiresIn) * time.Second; d/time.Second == time.Duration(expiresIn) && d < maxExpiresIn {
+		return d
+	}
+	return maxExpiresIn
+}
+
+// oauthError is an error response from the token endpoint. The codes that
+// only a new authorization can fix come with that advice.
+type oauthError struct{ code, description string }
+
+func (e oauthError) Error() string {
+	msg := "token exchange failed: " + e.code
+	if e.description != "" {
+		msg += " (" + e.description + ")"
+	}
+	switch e.code {
+	case "invalid_client", "unauthorized_client":
+		msg += "; check OURA_CLIENT_ID and OURA_CLIENT_SECRET (was the secret rotated?) and run `oura-hr setup` again"
+	case "invalid_grant":
+		msg += "; the authorization was revoked or has expired, run `oura-hr setup` again"
+	}
+	return msg
+}
+
 func refresh(clientID, clientSecret string, old *storedTokens) (*storedTokens, error) {
 	t, err := exchangeToken(clientID, clientSecret, url.Values{
 		"grant_type":    {"refresh_token"},
@@ -118,133 +823,1170 @@ func refresh(clientID, clientSecret string, old *storedTokens) (*storedTokens, e
 	return t, nil
 }
 
-func runSetup(clientID, clientSecret string) {
+// browserCommands lists launchers to try in order: each entry of $BROWSER
+// (colon-separated, as with xdg-open) and then the per-OS default.
+func browserCommands(u string) []*exec.Cmd {
+	var cmds []*exec.Cmd
+	for _, b := range strings.Split(os.Getenv("BROWSER"), ":") {
+		if b != "" {
+			cmds = append(cmds, exec.Command(b, u))
+		}
+	}
+	switch runtime.GOOS {
+	case "darwin":
+		// Usually /usr/bin/open, but it may live elsewhere
+		open := "/usr/bin/open"
+		if p, err := exec.LookPath("open"); err == nil {
+			open = p
+		}
+		cmds = append(cmds, exec.Command(open, u))
+	case "windows":
+		cmds = append(cmds, exec.Command("rundll32", "url.dll,FileProtocolHandler", u))
+	default:
+		cmds = append(cmds, exec.Command("xdg-open", u))
+	}
+	return cmds
+}
+
+// openBrowser starts the first launcher that can be run. One that starts
+// but then fails, like xdg-open without a handler, is reported from the
+// background so setup keeps waiting for the callback either way.
+func openBrowser(u string) error {
+	var err error
+	for _, cmd := range browserCommands(u) {
+		if err = cmd.Start(); err == nil {
+			go func() {
+				if err := cmd.Wait(); err != nil {
+					fmt.Fprintf(os.Stderr, "%s failed: %v\nPlease open the URL above manually.\n", cmd.Args[0], err)
+				}
+			}()
+			return nil
+		}
+	}
+	return err
+}
+
+func getWithToken(ctx context.Context, reqURL, accessToken, etag string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", reqURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	return doWithRetry(httpClient(), req)
+}
+
+// apiClient makes authenticated requests against the Oura API.
+type apiClient struct {
+	clientID     string
+	clientSecret string
+	tokens       *storedTokens // nil when authenticating with a PAT
+	accessToken  string
+	unsaved      bool // tokens were refreshed but couldn't be saved
+}
+
+// do performs an authenticated GET, conditional on etag if it's set. The
+// stored expiry can't catch clock skew or server-side revocation, so a 401
+// gets one refresh and retry before the response is returned.
+func (c *apiClient) do(ctx context.Context, reqURL, etag string) (*http.Response, error) {
+	debugf("GET %s", reqURL)
+	resp, err := getWithToken(ctx, reqURL, c.accessToken, etag)
+	if err != nil {
+		return nil, err
+	}
+	debugf("HTTP %s", resp.Status)
+	if resp.StatusCode == http.StatusUnauthorized && c.tokens != nil {
+		debugf("unauthorized, refreshing tokens and retrying")
+		resp.Body.Close()
+		if err := c.refreshTokens(); err != nil {
+			return nil, err
+		}
+		if resp, err = getWithToken(ctx, reqURL, c.accessToken, etag); err != nil {
+			return nil, err
+		}
+		debugf("HTTP %s", resp.Status)
+	}
+	return resp, nil
+}
+
+// get performs an authenticated GET and returns the response body. With
+// revalidate, responses that came with an ETag are kept and revalidated
+// with If-None-Match next time, so an unchanged one isn't transferred
+// again. That's only worth it for the daily endpoints, which are queried
+// by date; heart rate queries end at the current second and never repeat.
+func (c *apiClient) get(ctx context.Context, reqURL string, revalidate bool) ([]byte, error) {
+	var prev etagEntry
+	var known bool
+	if revalidate {
+		prev, known = loadETags()[reqURL]
+	}
+	resp, err := c.do(ctx, reqURL, prev.ETag)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified && known {
+		debugf("not modified, using the stored response")
+		rememberETag(reqURL, prev.ETag, []byte(prev.Body))
+		return []byte(prev.Body), nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s", reqURL, resp.Status)
+	}
+	body, err := readBody(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if etag := resp.Header.Get("ETag"); etag != "" && revalidate {
+		rememberETag(reqURL, etag, body)
+	}
+	return body, nil
+}
+
+// page is one page of a usercollection endpoint.
+type page[T any] struct {
+	Data      []T    `json:"data"`
+	NextToken string `json:"next_token"`
+}
+
+// sandbox reports whether OURA_SANDBOX=1 selects Oura's sandbox, which
+// serves sample data for any token, so no ring or OAuth app is needed.
+func sandbox() bool { return setting("OURA_SANDBOX") == "1" }
+
+// apiEndpoint is the URL of a usercollection endpoint. OURA_API_URL replaces
+// the collection base, e.g. to point at a mock server.
+func apiEndpoint(name string) string {
+	base := apiURL
+	if sandbox() {
+		base = sandboxURL
+	}
+	return strings.TrimSuffix(envOr("OURA_API_URL", base), "/") + "/" + name
+}
+
+// eachPage calls fn with every page of endpoint in turn, following
+// next_token, so large ranges never have to be held in memory at once.
+func eachPage[T any](ctx context.Context, c *apiClient, endpoint string, query url.Values, fn func([]T) error) error {
+	for {
+		body, err := c.get(ctx, apiEndpoint(endpoint)+"?"+query.Encode(), endpoint != heartRateEndpoint)
+		if err != nil {
+			return err
+		}
+		var p page[T]
+		if err := json.Unmarshal(body, &p); err != nil {
+			return err
+		}
+		if err := fn(p.Data); err != nil {
+			return err
+		}
+		if p.NextToken == "" {
+			return nil
+		}
+		query.Set("next_token", p.NextToken)
+	}
+}
+
+// fetchAll collects every page of endpoint.
+func fetchAll[T any](ctx context.Context, c *apiClient, endpoint string, query url.Values) ([]T, error) {
+	var all []T
+	err := eachPage(ctx, c, endpoint, query, func(data []T) error {
+		all = append(all, data...)
+		return nil
+	})
+	return all, err
+}
+
+func heartRateQuery(start, end time.Time) url.Values {
+	return url.Values{
+		"start_datetime": {start.Format(time.RFC3339)},
+		"end_datetime":   {end.Format(time.RFC3339)},
+	}
+}
+
+// heartRate fetches every heart-rate reading between start and end.
+func (c *apiClient) heartRate(ctx context.Context, start, end time.Time) ([]hrEntry, error) {
+	return fetchAll[hrEntry](ctx, c, heartRateEndpoint, heartRateQuery(start, end))
+}
+
+// entryTime parses an entry's timestamp, returning the zero time if it
+// can't be parsed.
+func entryTime(e hrEntry) time.Time {
+	ts, _ := time.Parse(time.RFC3339, e.Timestamp)
+	return ts
+}
+
+// filterSource keeps the entries from the sources in OURA_HR_SOURCE
+// (comma-separated, e.g. awake,rest). If none match, or it's unset, all
+// entries are kept.
+func filterSource(entries []hrEntry) []hrEntry {
+	v := setting("OURA_HR_SOURCE")
+	if v == "" {
+		return entries
+	}
+	sources := strings.Split(v, ",")
+	var matched []hrEntry
+	for _, e := range entries {
+		if slices.Contains(sources, e.Source) {
+			matched = append(matched, e)
+		}
+	}
+	if len(matched) == 0 {
+		debugf("no %s readings in the window, using all sources", v)
+		return entries
+	}
+	return matched
+}
+
+// displayLocation is the OURA_TZ time zone (an IANA name such as
+// Europe/Stockholm), or the local one. It's resolved once so a bad name
+// is only warned about once.
+var displayLocation = sync.OnceValue(func() *time.Location {
+	name := setting("OURA_TZ")
+	if name == "" {
+		return time.Local
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		warnf("ignoring OURA_TZ: %v", err)
+		return time.Local
+	}
+	return loc
+})
+
+// localTimes reports whether timestamps are shown in displayLocation
+// rather than as the API sent them: always with --local, and outside JSON
+// when OURA_TZ is set. JSON keeps the API's values by default since it's
+// read by programs.
+func localTimes(json bool) bool {
+	return *localFlag || (!json && setting("OURA_TZ") != "")
+}
+
+// displayEntry returns e with its timestamp converted for display.
+// Unparseable timestamps are left alone.
+func displayEntry(e hrEntry, json bool) hrEntry {
+	if ts := entryTime(e); localTimes(json) && !ts.IsZero() {
+		e.Timestamp = ts.In(displayLocation()).Format(time.RFC3339)
+	}
+	return e
+}
+
+// latestEntry returns the reading with the newest timestamp, since the API
+// doesn't guarantee the data is sorted. Entries with unparseable timestamps
+// are skipped; if none parse, the last entry is returned.
+func latestEntry(entries []hrEntry) hrEntry {
+	latest := entries[len(entries)-1]
+	var latestAt time.Time
+	for _, e := range entries {
+		ts, err := time.Parse(time.RFC3339, e.Timestamp)
+		if err != nil {
+			continue
+		}
+		if latestAt.IsZero() || ts.After(latestAt) {
+			latest, latestAt = e, ts
+		}
+	}
+	return latest
+}
+
+// receiveCode runs the local callback server, sends the user to
+// authorizationURL and waits for Oura to redirect back with a code.
+// Callbacks without the state we sent are rejected to prevent CSRF.
+func receiveCode(authorizationURL, state string) string {
 	codeCh := make(chan string, 1)
 	mux := http.NewServeMux()
-	srv := &http.Server{Addr: ":8085", Handler: mux}
+	srv := &http.Server{Addr: fmt.Sprintf(":%d", redirectPort()), Handler: mux}
 
 	mux.HandleFunc("/callback", func(w http.ResponseWriter, r *http.Request) {
-		code := r.URL.Query().Get("code")
-		fmt.Fprintf(w, "<html><body><h2>%s</h2><p>You can close this tab.</p></body></html>",
-			map[bool]string{true: "Authorization successful!", false: "Error: no code received"}[code != ""])
+		q := r.URL.Query()
+		code, msg := q.Get("code"), "Authorization successful!"
+		switch {
+		case q.Get("state") != state:
+			code, msg = "", "Error: state mismatch, please run setup again"
+			w.WriteHeader(http.StatusBadRequest)
+		case code == "":
+			msg = "Error: no code received"
+		}
+		fmt.Fprintf(w, "<html><body><h2>%s</h2><p>You can close this tab.</p></body></html>", msg)
 		codeCh <- code
 	})
 
-	go srv.ListenAndServe()
-	time.Sleep(100 * time.Millisecond) // let the server start
-
-	authorizationURL := fmt.Sprintf("%s?response_type=code&client_id=%s&redirect_uri=%s&scope=%s",
-		authURL, url.QueryEscape(clientID), url.QueryEscape(redirectURI), scope)
+	// Bind before opening the browser: if the port is taken, the callback
+	// could never arrive and setup would wait forever. Another port only
+	// helps if it's registered as the app's redirect URI, so that's left to
+	// the user.
+	ln, err := net.Listen("tcp", srv.Addr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not listen for the OAuth callback on port %d: %v\n", redirectPort(), err)
+		fmt.Fprintln(os.Stderr, "Free the port, or set OURA_REDIRECT_PORT to another one registered as a redirect URI of your app.")
+		os.Exit(1)
+	}
+	go srv.Serve(ln)
 
-	fmt.Println("Opening browser for Oura authorization...")
-	fmt.Println("If the browser doesn't open, visit:")
+	// The URL goes out first so it's there whatever the launcher does
+	fmt.Println("Visit this URL to authorize oura-hr:")
 	fmt.Println(authorizationURL)
-	if err := exec.Command("/usr/bin/open", authorizationURL).Start(); err != nil {
-		fmt.Fprintf(os.Stderr, "Could not open browser automatically: %v\n", err)
-		fmt.Println("Please open the URL above manually.")
+	fmt.Println()
+	fmt.Println("Opening it in your browser...")
+	if err := openBrowser(authorizationURL); err != nil {
+		fmt.Fprintf(os.Stderr, "Could not open a browser: %v\n", err)
+		fmt.Fprintln(os.Stderr, "Please open the URL above manually, or set $BROWSER. On a machine without a browser, use `oura-hr setup --manual`.")
+	}
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigCh)
+
+	var code string
+	var interrupted bool
+	select {
+	case code = <-codeCh:
+	case <-sigCh:
+		interrupted = true
 	}
 
-	code := <-codeCh
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
 	defer cancel()
 	srv.Shutdown(ctx)
 
+	if interrupted {
+		fmt.Fprintln(os.Stderr, "\nSetup interrupted.")
+		os.Exit(130)
+	}
+	return code
+}
+
+// promptForCode is the headless alternative to receiveCode: the user opens
+// authorizationURL anywhere and pastes the code (or the whole redirect URL)
+// back into the terminal. A pasted URL must carry the state we sent.
+func promptForCode(authorizationURL, state string, in io.Reader) string {
+	fmt.Println("Visit this URL to authorize oura-hr:")
+	fmt.Println(authorizationURL)
+	fmt.Println()
+	fmt.Printf("You will be redirected to %s?code=..., which may fail to load.\n", redirectURI())
+	fmt.Print("Paste the code parameter (or the whole URL) here: ")
+
+	line, _ := bufio.NewReader(in).ReadString('\n')
+	line = strings.TrimSpace(line)
+	if u, err := url.Parse(line); err == nil && u.Query().Has("code") {
+		if u.Query().Get("state") != state {
+			fmt.Fprintln(os.Stderr, "State mismatch in the pasted URL.")
+			return ""
+		}
+		return u.Query().Get("code")
+	}
+	return line
+}
+
+func randomToken() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// pkceChallenge is the S256 code_challenge (RFC 7636) for a random
+// code_verifier, so the flow doesn't depend on a client secret alone.
+func pkceChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// pendingSetup is the PKCE verifier and state behind a URL printed by
+// `setup --print-url`, kept so `setup --manual` can finish the flow later.
+type pendingSetup struct {
+	Verifier string `json:"verifier"`
+	State    string `json:"state"`
+}
+
+func pendingSetupPath() string { return statePath("oura-hr-setup.json") }
+
+func loadPendingSetup() (pendingSetup, bool) {
+	var p pendingSetup
+	data, err := os.ReadFile(pendingSetupPath())
+	if err != nil || json.Unmarshal(data, &p) != nil || p.Verifier == "" {
+		return pendingSetup{}, false
+	}
+	return p, true
+}
+
+func buildAuthURL(clientID string, p pendingSetup) string {
+	return envOr("OURA_AUTH_URL", authURL) + "?" + url.Values{
+		"response_type":         {"code"},
+		"client_id":             {clientID},
+		"redirect_uri":          {redirectURI()},
+		"scope":                 {scopes()},
+		"code_challenge":        {pkceChallenge(p.Verifier)},
+		"code_challenge_method": {"S256"},
+		"state":                 {p.State},
+	}.Encode()
+}
+
+// printSetupURL prints the authorization URL and saves what's needed to
+// exchange its code, without waiting for one.
+func printSetupURL(clientID string) {
+	p := pendingSetup{Verifier: randomToken(), State: randomToken()}
+	data, _ := json.Marshal(p)
+	err := ensureCacheDir()
+	if err == nil {
+		err = writeFileAtomic(pendingSetupPath(), data, 0o600)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not save the setup state: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Println(buildAuthURL(clientID, p))
+}
+
+func runSetup(clientID, clientSecret string, manual bool) {
+	p := pendingSetup{Verifier: randomToken(), State: randomToken()}
+	if pending, ok := loadPendingSetup(); ok && manual {
+		debugf("continuing the setup from --print-url")
+		p = pending
+	}
+	authorizationURL := buildAuthURL(clientID, p)
+
+	var code string
+	if manual {
+		code = promptForCode(authorizationURL, p.State, os.Stdin)
+	} else {
+		code = receiveCode(authorizationURL, p.State)
+	}
+
 	if code == "" {
 		fmt.Fprintln(os.Stderr, "No authorization code received.")
 		os.Exit(1)
 	}
 
 	t, err := exchangeToken(clientID, clientSecret, url.Values{
-		"grant_type":   {"authorization_code"},
-		"code":         {code},
-		"redirect_uri": {redirectURI},
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {redirectURI()},
+		"code_verifier": {p.Verifier},
 	})
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Setup failed: %v\n", err)
 		os.Exit(1)
 	}
 
-	saveTokens(t)
-	fmt.Printf("Done! Tokens saved to %s\n", tokenPath())
+	if err := saveTokens(t); err != nil {
+		fmt.Fprintf(os.Stderr, "Could not save tokens: %v\n", err)
+		os.Exit(1)
+	}
+	os.Remove(pendingSetupPath())
+	fmt.Printf("Done! Tokens saved to %s\n", tokens().location())
 }
 
-func main() {
-	// Handle setup before the silent-exit check so we can print useful errors
-	if len(os.Args) > 1 && os.Args[1] == "setup" {
-		clientID := os.Getenv("OURA_CLIENT_ID")
-		clientSecret := os.Getenv("OURA_CLIENT_SECRET")
-		if clientID == "" || clientSecret == "" {
-			fmt.Fprintln(os.Stderr, "Error: OURA_CLIENT_ID and OURA_CLIENT_SECRET must be set.")
-			fmt.Fprintln(os.Stderr, "Hint:  source ~/.secrets && ~/.dotfiles/oura-hr/oura-hr setup")
-			os.Exit(1)
+// newClient authenticates with OURA_PAT or the stored OAuth tokens,
+// refreshing them if they're about to expire. A missing token is reported
+// as os.ErrNotExist.
+func newClient() (*apiClient, error) {
+	clientID := setting("OURA_CLIENT_ID")
+	clientSecret := setting("OURA_CLIENT_SECRET")
+
+	if sandbox() {
+		debugf("sandbox mode: using OURA_SANDBOX_TOKEN against %s", apiEndpoint(""))
+		return &apiClient{accessToken: setting("OURA_SANDBOX_TOKEN")}, nil
+	}
+
+	// A personal access token takes precedence over stored OAuth tokens
+	client := &apiClient{clientID: clientID, clientSecret: clientSecret, accessToken: setting("OURA_PAT")}
+	if client.accessToken != "" {
+		debugf("using OURA_PAT")
+		return client, nil
+	}
+
+	t, err := loadTokens()
+	if err != nil {
+		debugf("loading tokens: %v", err)
+		return nil, err
+	}
+	debugf("loaded tokens from %s, expiring %s", tokens().location(), t.ExpiresAt.Format(time.RFC3339))
+	client.tokens, client.accessToken = t, t.AccessToken
+	return client, client.refreshIfExpiring()
+}
+
+// refreshIfExpiring refreshes OAuth tokens within 60s of expiry, first
+// retrying the save of refreshed tokens that couldn't be saved. It's a
+// no-op for PATs.
+func (c *apiClient) refreshIfExpiring() error {
+	if c.unsaved {
+		if err := c.saveRefreshed(); err != nil {
+			return err
 		}
-		runSetup(clientID, clientSecret)
-		return
 	}
+	if c.tokens == nil || time.Now().Before(c.tokens.ExpiresAt.Add(-60*time.Second)) {
+		return nil
+	}
+	debugf("access token expired, refreshing")
+	return c.refreshTokens()
+}
+
+// refreshTokens refreshes and saves the tokens while holding the token
+// lock. If another process refreshed them while we waited, its tokens are
+// adopted instead, so a rotated refresh token is never spent twice. The
+// refreshed tokens are used even if saving them fails, as the old refresh
+// token may already be spent; the error is returned and the save retried
+// by refreshIfExpiring.
+func (c *apiClient) refreshTokens() error {
+	unlock, err := lockFile(tokenPath()+".lock", true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if t, err := loadTokens(); err == nil && !c.unsaved && t.AccessToken != c.accessToken {
+		debugf("tokens were refreshed by another process")
+		c.tokens, c.accessToken = t, t.AccessToken
+		return nil
+	}
+
+	if c.tokens.RefreshToken == "" {
+		return errNoRefreshToken
+	}
+	t, err := refresh(c.clientID, c.clientSecret, c.tokens)
+	if err != nil {
+		return fmt.Errorf("%w: %w", errRefresh, err)
+	}
+	c.tokens, c.accessToken = t, t.AccessToken
+	if err := saveTokens(t); err != nil {
+		c.unsaved = true
+		return unsavedError(err)
+	}
+	return nil
+}
+
+// saveRefreshed retries saving tokens refreshTokens couldn't save.
+func (c *apiClient) saveRefreshed() error {
+	unlock, err := lockFile(tokenPath()+".lock", true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if err := saveTokens(c.tokens); err != nil {
+		return unsavedError(err)
+	}
+	debugf("saved the refreshed tokens")
+	c.unsaved = false
+	return nil
+}
+
+func unsavedError(err error) error {
+	return fmt.Errorf("saving the refreshed tokens: %w (they're kept in memory until a save succeeds)", err)
+}
+
+// Exit codes of the status-bar path, so scripts can tell failures apart.
+// 2 is left to the flag package for usage errors.
+const (
+	exitSetup   = 3 // no credentials or stored tokens: run setup
+	exitRefresh = 4 // the OAuth token refresh failed
+	exitNetwork = 5 // the API couldn't be reached or returned an error
+	exitNoData  = 6 // the API returned no readings
+)
+
+var (
+	// errNoData reports an empty result. Unlike other failures it never
+	// falls back to the stale cache.
+	errNoData = errors.New("no data")
+
+	errRefresh = errors.New("token refresh failed")
+
+	// errNoRefreshToken means the tokens can't be refreshed at all, e.g.
+	// after a partial exchange, so only running setup again helps.
+	errNoRefreshToken = errors.New("no refresh token stored, run `oura-hr setup` again")
+)
+
+// silentError is a failure the status-bar path reports only through its
+// exit code, so a bar shows nothing rather than an error message. With
+// OURA_HR_SILENT=1 the code is 0 as well.
+type silentError struct {
+	code int
+	err  error
+}
+
+func (e silentError) Error() string { return e.err.Error() }
+func (e silentError) Unwrap() error { return e.err }
 
-	clientID := os.Getenv("OURA_CLIENT_ID")
-	clientSecret := os.Getenv("OURA_CLIENT_SECRET")
-	if clientID == "" || clientSecret == "" {
+// formatError is a failure rendering output with the configured format.
+type formatError struct{ err error }
+
+func (e formatError) Error() string { return "invalid output format: " + e.err.Error() }
+func (e formatError) Unwrap() error { return e.err }
+
+// reportable reports whether err has to be surfaced even on the status-bar
+// path: a broken token store or output format needs fixing, not hiding
+// behind the stale cache.
+func reportable(err error) bool {
+	var se storeError
+	var fe formatError
+	return errors.As(err, &se) || errors.As(err, &fe)
+}
+
+// exitWithCode prints err and exits with the status-bar exit code for it,
+// for commands that report errors but still want scriptable codes.
+func exitWithCode(err error) {
+	code := exitNetwork
+	switch {
+	case reportable(err):
+		code = 1
+	case errors.Is(err, os.ErrNotExist):
+		code = exitSetup
+		err = fmt.Errorf("no tokens in %s, run `oura-hr setup` to authorize", tokens().location())
+	case errors.Is(err, errNoRefreshToken):
+		code = exitSetup
+	case errors.Is(err, errRefresh):
+		code = exitRefresh
+	}
+	fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+	os.Exit(code)
+}
+
+func silentMode() bool { return setting("OURA_HR_SILENT") == "1" }
+
+// exitOnError exits with the status for err: its code for silent errors
+// (0 in silent mode), 1 with the error printed for anything else. It
+// returns if err is nil.
+func exitOnError(err error) {
+	var se silentError
+	switch {
+	case err == nil:
+		return
+	case errors.As(err, &se) && silentMode():
 		os.Exit(0)
+	case errors.As(err, &se):
+		os.Exit(se.code)
 	}
+	fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+	os.Exit(1)
+}
 
-	// Serve from cache if fresh
-	cache := cachePath()
-	if info, err := os.Stat(cache); err == nil {
-		if int(time.Since(info.ModTime()).Seconds()) < ttl() {
-			if data, err := os.ReadFile(cache); err == nil {
-				fmt.Print(string(data))
-				return
-			}
+// runCached writes the cached output for key to w while it's fresh.
+// Otherwise it authenticates, calls fetch for fresh output, and caches and
+// writes that.
+func runCached(ctx context.Context, w io.Writer, key string, fetch func(context.Context, *apiClient) (string, error)) error {
+	return runCachedUntil(ctx, w, key, func(ctx context.Context, client *apiClient) (string, time.Time, error) {
+		output, err := fetch(ctx, client)
+		return output, time.Time{}, err
+	})
+}
+
+// cacheUntilMagic starts cached output that mustn't be shown after the
+// time on the rest of the line, for output that goes out of date by
+// itself rather than by the TTL.
+const cacheUntilMagic = "oura-hr-until "
+
+// splitCache returns the output in cached data and the time it may be
+// shown until, zero if there's no limit.
+func splitCache(data []byte) (output []byte, until time.Time) {
+	header, rest, ok := bytes.Cut(data, []byte("\n"))
+	if !ok || !bytes.HasPrefix(header, []byte(cacheUntilMagic)) {
+		return data, time.Time{}
+	}
+	until, _ = time.Parse(time.RFC3339, string(header[len(cacheUntilMagic):]))
+	return rest, until
+}
+
+// runCachedUntil is runCached for fetch funcs whose output may only be
+// shown until a given time, zero for no limit. Past that, it's neither
+// served from the cache nor as a stale fallback.
+func runCachedUntil(ctx context.Context, w io.Writer, key string, fetch func(context.Context, *apiClient) (string, time.Time, error)) error {
+	if sandbox() {
+		key += "+sandbox" // never mixed up with real data
+	} else if setting("OURA_PAT") == "" && setting("OURA_CLIENT_ID") == "" {
+		debugf("neither OURA_PAT nor OURA_CLIENT_ID is set")
+		return silentError{exitSetup, errors.New("no credentials")}
+	}
+	if err := checkCacheDir(); err != nil {
+		return err
+	}
+
+	// Serve from cache if fresh. The cache is still read with it disabled,
+	// as the fallback for a failed fetch.
+	cache := cachePath(key)
+	maxAge := ttl()
+	var cached []byte
+	var cacheAge int
+	unlock := lockCache(cache, false)
+	info, err := os.Stat(cache)
+	if err == nil {
+		cached, err = os.ReadFile(cache)
+	}
+	unlock()
+	if err == nil {
+		cacheAge = int(time.Since(info.ModTime()).Seconds())
+		var until time.Time
+		cached, until = splitCache(cached)
+		switch {
+		case !until.IsZero() && time.Now().After(until):
+			debugf("cache not used: %s expired at %s", cache, until.Format(time.RFC3339))
+			cached = nil
+		case maxAge > 0 && !*noCacheFlag && cacheAge < maxAge:
+			debugf("cache hit: %s is %ds old (ttl %ds)", cache, cacheAge, maxAge)
+			_, err := w.Write(cached)
+			return err
+		default:
+			debugf("cache not used: %s is %ds old (ttl %ds, --no-cache %t)", cache, cacheAge, maxAge, *noCacheFlag)
 		}
+	} else {
+		debugf("cache miss: %v", err)
 	}
 
-	t, err := loadTokens()
+	// When a fetch fails, fall back to the cache until it's past the stale TTL
+	serveStale := func(err error) error {
+		if reportable(err) {
+			return err
+		}
+		debugf("fetch failed: %v", err)
+		if cached != nil && cacheAge < staleTTL() {
+			debugf("serving stale cache (%ds old, stale ttl %ds)", cacheAge, staleTTL())
+			logPoll("fetch failed, showing the %ds old cached value: %v", cacheAge, err)
+			_, err := w.Write(cached)
+			return err
+		}
+		if errors.Is(err, errNoRefreshToken) {
+			return silentError{exitSetup, err}
+		}
+		if errors.Is(err, errRefresh) {
+			return silentError{exitRefresh, err}
+		}
+		return silentError{exitNetwork, err}
+	}
+
+	client, err := newClient()
 	if err != nil {
-		os.Exit(0) // Not set up yet — silent
+		if errors.Is(err, os.ErrNotExist) && !reportable(err) {
+			return silentError{exitSetup, err} // Not set up yet
+		}
+		return serveStale(err)
+	}
+
+	output, until, err := fetch(ctx, client)
+	if errors.Is(err, errNoData) {
+		return silentError{exitNoData, err}
+	}
+	if err != nil {
+		return serveStale(err)
+	}
+	if err := ensureCacheDir(); err != nil {
+		debugf("not caching: %v", err)
+	} else {
+		data := []byte(output)
+		if !until.IsZero() {
+			data = append([]byte(cacheUntilMagic+until.Format(time.RFC3339)+"\n"), data...)
+		}
+		unlock := lockCache(cache, true)
+		if err := writeFileAtomic(cache, data, 0o600); err != nil {
+			debugf("writing cache: %v", err)
+		}
+		unlock()
+	}
+	_, err = io.WriteString(w, output)
+	return err
+}
+
+// lockCache takes the advisory lock on cache, shared for reading or
+// exclusive for writing. Atomic writes already rule out torn files; the
+// lock also keeps a reader from pairing one version's modification time
+// with another's contents. Without it that race is all that's lost, so a
+// failure to lock is only logged.
+func lockCache(cache string, exclusive bool) (unlock func()) {
+	unlock, err := lockFile(cache+".lock", exclusive)
+	if err != nil {
+		debugf("not locking the cache: %v", err)
+		return func() {}
+	}
+	return unlock
+}
+
+// outputKey is the cache key for formatName under the current settings.
+// Every setting that shapes the output is part of it, so changing one
+// fetches afresh instead of replaying output rendered under the old one.
+// With everything at its default it's just formatName.
+func outputKey(formatName string, colored bool) string {
+	key := []string{formatName}
+	add := func(on bool, opt string, args ...any) {
+		if on {
+			key = append(key, fmt.Sprintf(opt, args...))
+		}
+	}
+	add(colored, "color=%d-%d", envInt("OURA_HR_ZONE_LOW", defaultZoneLow), envInt("OURA_HR_ZONE_HIGH", defaultZoneHigh))
+	add(glyph() != defaultGlyph, "glyph=%s", glyph())
+	add(formatName == "polybar" && polybarRamp() != nil, "ramp=%s@%d-%d", strings.Join(polybarRamp(), ","), envInt("OURA_HR_ZONE_LOW", defaultZoneLow), envInt("OURA_HR_ZONE_HIGH", defaultZoneHigh))
+	add(formatName == "waybar", "elevated=%d", elevatedBPM())
+	add(window() != defaultWindow, "window=%s", window())
+	add(aggregateMode() != "", "aggregate=%s", aggregateMode())
+	add(setting("OURA_HR_PICK") != "", "pick=%s", setting("OURA_HR_PICK"))
+	add(setting("OURA_HR_SOURCE") != "", "source=%s", setting("OURA_HR_SOURCE"))
+	add(trendEnabled(), "trend")
+	add(rangeEnabled(), "range")
+	add(*zoneFlag, "zone=%d/%v", maxHR(), zoneBands())
+	add(pctEnabled(), "pct=%d", maxHR())
+	add(*sparkFlag, "sparkline=%d", historyLength())
+	add(*localFlag || setting("OURA_TZ") != "", "tz=%s/%t", displayLocation(), *localFlag)
+	add(minPoints() > 1, "min=%d", minPoints())
+	add(readingMaxAge() > 0, "maxage=%s", readingMaxAge())
+	add(staleAfter() > 0, "stale=%s", staleAfter())
+	add(*withAgeFlag, "age")
+	add(smoothAlpha() > 0, "smooth=%g", smoothAlpha())
+	return strings.Join(key, "+")
+}
+
+// readingMaxAge is OURA_HR_MAX_AGE, the oldest a reading may be to be
+// shown at all, or 0 for no limit.
+func readingMaxAge() time.Duration {
+	if v := setting("OURA_HR_MAX_AGE"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			return d
+		}
+	}
+	return 0
+}
+
+// shownUntil is when e gets older than readingMaxAge, zero if there's no
+// limit. It's errNoData if e is past it already, so the empty placeholder
+// is shown instead.
+func shownUntil(e hrEntry) (time.Time, error) {
+	limit, ts := readingMaxAge(), entryTime(e)
+	if limit == 0 || ts.IsZero() {
+		return time.Time{}, nil
+	}
+	if until := ts.Add(limit); time.Now().Before(until) {
+		return until, nil
+	}
+	debugf("latest reading is older than OURA_HR_MAX_AGE %s", limit)
+	return time.Time{}, errNoData
+}
+
+// minPoints is OURA_HR_MIN_POINTS, the fewest readings the window must
+// hold for one to be shown.
+func minPoints() int { return max(1, envInt("OURA_HR_MIN_POINTS", 1)) }
+
+// heartRateOutput returns the cache key for the configured output and a
+// fetch func rendering the latest heart rate with it.
+func heartRateOutput(formatName string, format func(reading) (string, error)) (string, func(context.Context, *apiClient) (string, time.Time, error)) {
+	colored := colorEnabled() && (formatName == "" || formatName == "plain" || formatName == "tmux" || formatName == "polybar" || formatName == "i3blocks" || formatName == "xbar")
+	return outputKey(formatName, colored), func(ctx context.Context, client *apiClient) (string, time.Time, error) {
+		now := time.Now().UTC()
+		entries, err := client.heartRate(ctx, now.Add(-window()), now)
+		if err != nil {
+			return "", time.Time{}, err
+		}
+		debugf("got %d entries", len(entries))
+		if len(entries) == 0 {
+			return "", time.Time{}, errNoData
+		}
+		entries = filterSource(entries)
+		if n := minPoints(); len(entries) < n {
+			// Too few readings to trust, e.g. a lone outlier: look twice as far back once
+			debugf("%d entries, fewer than OURA_HR_MIN_POINTS %d, widening the window to %s", len(entries), n, 2*window())
+			if entries, err = client.heartRate(ctx, now.Add(-2*window()), now); err != nil {
+				return "", time.Time{}, err
+			}
+			if entries = filterSource(entries); len(entries) < n {
+				return "", time.Time{}, errNoData
+			}
+		}
+
+		until, err := shownUntil(latestEntry(entries))
+		if err != nil {
+			return "", time.Time{}, err
+		}
+
+		maybeAlert(latestEntry(entries))
+
+		r := reading{hrEntry: aggregate(entries, aggregateMode())}
+		r.BPM = smoothBPM(r.hrEntry)
+		if trendEnabled() {
+			r.Trend = trend(entries)
+		}
+		if rangeEnabled() {
+			r.Min, r.Max = bpmRange(entries)
+		}
+		r.Stale = isStale(latestEntry(entries))
+		logPoll("fetched: %d bpm at %s (%s)", r.BPM, r.Timestamp, r.Source)
+		if ts := entryTime(latestEntry(entries)); *withAgeFlag && !ts.IsZero() {
+			r.Age = humanizeAge(max(0, time.Since(ts)))
+		}
+		if colored {
+			r.color = zoneColor(r.BPM)
+		}
+		if *zoneFlag {
+			if max := maxHR(); max > 0 {
+				r.Zone = hrZone(r.BPM, max)
+			} else {
+				debugf("--zone needs OURA_MAX_HR or OURA_AGE")
+			}
+		}
+		if pctEnabled() {
+			if hr := maxHR(); hr > 0 {
+				r.Pct = min(max(r.BPM*100/hr, 0), 100)
+			} else {
+				debugf("--pct needs OURA_MAX_HR or OURA_AGE, showing BPM")
+			}
+		}
+		if *sparkFlag {
+			var bpms []int
+			for _, e := range appendHistory(latestEntry(entries)) {
+				bpms = append(bpms, e.BPM)
+			}
+			r.Sparkline = sparkline(bpms)
+		}
+		output, err := format(r)
+		if err != nil {
+			return "", time.Time{}, formatError{err}
+		}
+		return output, until, nil
 	}
+}
 
-	// Refresh if within 60s of expiry
-	if time.Now().After(t.ExpiresAt.Add(-60 * time.Second)) {
-		t, err = refresh(clientID, clientSecret, t)
+// recentOutput returns the cache key and a fetch func listing the last
+// count readings in the window, as for --since/--until.
+func recentOutput(formatName string, count int) (string, func(context.Context, *apiClient) (string, time.Time, error)) {
+	key := fmt.Sprintf("%s+count=%d", outputKey(formatName, false), count)
+	return key, func(ctx context.Context, client *apiClient) (string, time.Time, error) {
+		now := time.Now().UTC()
+		entries, err := client.heartRate(ctx, now.Add(-window()), now)
 		if err != nil {
-			os.Exit(0)
+			return "", time.Time{}, err
 		}
-		saveTokens(t)
+		debugf("got %d entries", len(entries))
+		if len(entries) == 0 {
+			return "", time.Time{}, errNoData
+		}
+		entries = filterSource(entries)
+		until, err := shownUntil(latestEntry(entries))
+		if err != nil {
+			return "", time.Time{}, err
+		}
+		slices.SortStableFunc(entries, func(a, b hrEntry) int {
+			return entryTime(a).Compare(entryTime(b))
+		})
+		entries = entries[max(0, len(entries)-count):]
+
+		var b strings.Builder
+		err = writeEntries(&b, entries, formatName)
+		return b.String(), until, err
 	}
+}
+
+// config is the resolved command line of the default command.
+type config struct {
+	format       string // output format name, see newFormatter
+	since, until string // historical range; both empty for the latest reading
+	count        int    // readings to list; 1 shows just the latest
+	noEmpty      bool   // report errNoData instead of printing OURA_HR_EMPTY
+}
 
-	now := time.Now().UTC()
-	reqURL := fmt.Sprintf("%s?start_datetime=%s&end_datetime=%s",
-		apiURL, now.Add(-4*time.Hour).Format(time.RFC3339), now.Format(time.RFC3339))
+func flagConfig() config {
+	return config{format: outputFormat(), since: *sinceFlag, until: *untilFlag, count: *countFlag}
+}
 
-	req, err := http.NewRequest("GET", reqURL, nil)
+// run is the default command: it writes the latest heart rate, or the
+// readings in a range, to w.
+func run(ctx context.Context, cfg config, w io.Writer) error {
+	format, err := newFormatter(cfg.format)
 	if err != nil {
-		os.Exit(0)
+		return formatError{err}
 	}
-	req.Header.Set("Authorization", "Bearer "+t.AccessToken)
-
-	resp, err := (&http.Client{Timeout: 8 * time.Second}).Do(req)
+	if cfg.since != "" || cfg.until != "" {
+		return runRange(ctx, cfg, format, w)
+	}
+	key, fetch := heartRateOutput(cfg.format, format)
+	if cfg.count > 1 {
+		key, fetch = recentOutput(cfg.format, cfg.count)
+	}
+	err = runCachedUntil(ctx, w, key, fetch)
 	if err != nil {
-		os.Exit(0)
+		logPoll("no reading: %v", err)
 	}
-	defer resp.Body.Close()
+	if p := setting("OURA_HR_EMPTY"); p != "" && !cfg.noEmpty && errors.Is(err, errNoData) {
+		// Not cached, so the next run tries again
+		_, err = fmt.Fprintln(w, p)
+	}
+	return err
+}
 
-	if resp.StatusCode != 200 {
-		os.Exit(0)
+func setupCommand(args []string) {
+	fs := flag.NewFlagSet("setup", flag.ExitOnError)
+	manual := fs.Bool("manual", false, "paste the authorization code instead of running a local callback server")
+	printURL := fs.Bool("print-url", false, "only print the authorization URL; finish with --manual")
+	addProfileFlag(fs)
+	fs.Parse(args)
+
+	clientID := setting("OURA_CLIENT_ID")
+	clientSecret := setting("OURA_CLIENT_SECRET")
+	if clientID == "" {
+		fmt.Fprintln(os.Stderr, "Error: OURA_CLIENT_ID must be set.")
+		fmt.Fprintln(os.Stderr, "Hint:  source ~/.secrets && ~/.dotfiles/oura-hr/oura-hr setup")
+		os.Exit(1)
 	}
+	if *printURL {
+		printSetupURL(clientID)
+		return
+	}
+	runSetup(clientID, clientSecret, *manual)
+}
 
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		os.Exit(0)
+func logoutCommand(args []string) {
+	fs := flag.NewFlagSet("logout", flag.ExitOnError)
+	cache := fs.Bool("cache", false, "also delete cached output and history")
+	addProfileFlag(fs)
+	fs.Parse(args)
+
+	store := tokens()
+	if err := store.remove(); err == nil {
+		fmt.Printf("Removed tokens from %s\n", store.location())
+	} else if !errors.Is(err, os.ErrNotExist) {
+		fmt.Fprintf(os.Stderr, "Could not remove tokens: %v\n", err)
+		os.Exit(1)
 	}
 
-	var result hrResponse
-	if err := json.Unmarshal(body, &result); err != nil || len(result.Data) == 0 {
-		os.Exit(0)
+	var paths []string
+	if *cache {
+		paths = cacheFiles()
 	}
+	for _, p := range paths {
+		if err := os.Remove(p); err == nil {
+			fmt.Printf("Removed %s\n", p)
+		} else if !errors.Is(err, os.ErrNotExist) {
+			fmt.Fprintf(os.Stderr, "Could not remove %s: %v\n", p, err)
+			os.Exit(1)
+		}
+	}
+	fmt.Println("Logged out. Run `oura-hr setup` to authorize again.")
+}
+
+// statusCommand reports local token and credential state without touching
+// the network.
+func statusCommand(args []string) {
+	fs := flag.NewFlagSet("status", flag.ExitOnError)
+	addProfileFlag(fs)
+	fs.Parse(args)
+
+	present := map[bool]string{true: "set", false: "not set"}
+	fmt.Printf("OURA_CLIENT_ID:     %s\n", present[setting("OURA_CLIENT_ID") != ""])
+	fmt.Printf("OURA_CLIENT_SECRET: %s\n", present[setting("OURA_CLIENT_SECRET") != ""])
+	fmt.Printf("OURA_PAT:           %s\n", present[setting("OURA_PAT") != ""])
+
+	store := tokens()
+	t, err := store.load()
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		fmt.Printf("Tokens:             missing (%s)\n", store.location())
+		return
+	case err != nil:
+		fmt.Printf("Tokens:             unreadable (%s): %v\n", store.location(), err)
+		return
+	}
+	fmt.Printf("Tokens:             %s\n", store.location())
+	fmt.Printf("Expires at:         %s\n", t.ExpiresAt.Local().Format(time.RFC1123))
+	if d := time.Until(t.ExpiresAt); d > 0 {
+		fmt.Printf("Access token:       expires in %s\n", humanizeAge(d))
+	} else {
+		fmt.Printf("Access token:       expired %s ago\n", humanizeAge(-d))
+	}
+	if t.RefreshToken == "" {
+		fmt.Println("Refresh token:      missing, run `oura-hr setup` again")
+	}
+}
 
-	output := fmt.Sprintf("♥ %d\n", result.Data[len(result.Data)-1].BPM)
-	os.MkdirAll(filepath.Dir(cache), 0o755)
-	os.WriteFile(cache, []byte(output), 0o600)
-	fmt.Print(output)
+func main() {
+	if err := loadConfig(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Handle subcommands before the silent-exit check so we can print useful errors
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "setup":
+			setupCommand(os.Args[2:])
+			return
+		case "logout":
+			logoutCommand(os.Args[2:])
+			return
+		case "status":
+			statusCommand(os.Args[2:])
+			return
+		case "resting":
+			restingCommand(os.Args[2:])
+			return
+		case "readiness":
+			readinessCommand(os.Args[2:])
+			return
+		case "sleep":
+			sleepCommand(os.Args[2:])
+			return
+		case "export":
+			exportCommand(os.Args[2:])
+			return
+		case "watch":
+			watchCommand(os.Args[2:])
+			return
+		case "install-systemd":
+			systemdCommand(os.Args[2:])
+			return
+		case "token":
+			tokenCommand(os.Args[2:])
+			return
+		case "serve":
+			serveCommand(os.Args[2:])
+			return
+		case "doctor":
+			doctorCommand(os.Args[2:])
+			return
+		case "raw":
+			rawCommand(os.Args[2:])
+			return
+		case "summary":
+			summaryCommand(os.Args[2:])
+			return
+		case "temp":
+			tempCommand(os.Args[2:])
+			return
+		case "spo2":
+			spo2Command(os.Args[2:])
+			return
+		case "workout":
+			workoutCommand(os.Args[2:])
+			return
+		case "ping":
+			pingCommand(os.Args[2:])
+			return
+		case "version":
+			fmt.Println(versionString())
+			return
+		}
+	}
+
+	flag.Parse()
+	if *versionFlag {
+		fmt.Println(versionString())
+		return
+	}
+	if *refreshTokensFlag {
+		refreshStoredTokens()
+		return
+	}
+	if *outFlag == "" || *outFlag == "-" {
+		exitOnError(run(context.Background(), flagConfig(), os.Stdout))
+		return
+	}
+	var b bytes.Buffer
+	err := run(context.Background(), flagConfig(), &b)
+	if b.Len() > 0 {
+		// Keep the previous contents when there's nothing to show
+		exitOnError(writeOutput(b.String()))
+	}
+	exitOnError(err)
+}
+
+// writeOutput prints output, or with --out replaces the file with it in
+// one step so a bar reading the file never sees a partial line.
+func writeOutput(output string) error {
+	if *outFlag == "" || *outFlag == "-" {
+		_, err := io.WriteString(os.Stdout, output)
+		return err
+	}
+	return writeFileAtomic(*outFlag, []byte(output), 0o644)
 }
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// isolate points the cache and token files at a temp dir and clears the
+// settings that would change where or how they're stored.
+func isolate(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	t.Setenv("OURA_HR_DIR", dir)
+	for _, name := range []string{"OURA_CONFIG", "OURA_PROFILE", "OURA_TOKEN_STORE", "OURA_TOKEN_FILE", "OURA_CACHE_FILE", "OURA_TOKEN_PASSPHRASE", "OURA_PAT"} {
+		t.Setenv(name, "")
+	}
+	fileSettings = nil
+	return dir
+}
+
+// tokenServer is a token endpoint that rotates the refresh token on every
+// exchange and counts them.
+func tokenServer(t *testing.T, hits *atomic.Int32, body func(n int32) string) {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := hits.Add(1)
+		time.Sleep(50 * time.Millisecond) // widen the race window
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, body(n))
+	}))
+	t.Cleanup(srv.Close)
+	t.Setenv("OURA_TOKEN_URL", srv.URL)
+}
+
+func TestConcurrentRefreshExchangesOnce(t *testing.T) {
+	isolate(t)
+	var hits atomic.Int32
+	tokenServer(t, &hits, func(n int32) string {
+		return fmt.Sprintf(`{"access_token":"access-%d","refresh_token":"refresh-%d","expires_in":3600}`, n, n)
+	})
+	old := &storedTokens{AccessToken: "access-0", RefreshToken: "refresh-0", ExpiresAt: time.Now().Add(-time.Minute)}
+	if err := saveTokens(old); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	errs := make([]error, 2)
+	for i := range errs {
+		c := &apiClient{clientID: "id", tokens: old, accessToken: old.AccessToken}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = c.refreshTokens()
+		}()
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			t.Fatalf("refreshTokens: %v", err)
+		}
+	}
+	if n := hits.Load(); n != 1 {
+		t.Errorf("token endpoint hit %d times, want 1", n)
+	}
+	got, err := loadTokens()
+	if err != nil {
+		t.Fatalf("loading tokens: %v", err)
+	}
+	if got.AccessToken != "access-1" || got.RefreshToken != "refresh-1" {
+		t.Errorf("stored tokens = %+v, want the first exchange's", got)
+	}
+}
+
+func TestTokenLifetime(t *testing.T) {
+	tests := []struct {
+		expiresIn int
+		want      time.Duration
+	}{
+		{0, defaultExpiresIn},
+		{-5, defaultExpiresIn},
+		{3600, time.Hour},
+		{86400, 24 * time.Hour},
+		{int(maxExpiresIn/time.Second) + 1, maxExpiresIn},
+		{math.MaxInt, maxExpiresIn},
+	}
+	for _, tt := range tests {
+		if got := tokenLifetime(tt.expiresIn); got != tt.want {
+			t.Errorf("tokenLifetime(%d) = %s, want %s", tt.expiresIn, got, tt.want)
+		}
+	}
+}
+
+func TestExchangeTokenWithoutExpiresIn(t *testing.T) {
+	isolate(t)
+	var hits atomic.Int32
+	tokenServer(t, &hits, func(int32) string {
+		return `{"access_token":"access","refresh_token":"refresh"}`
+	})
+	got, err := exchangeToken("id", "", url.Values{"grant_type": {"refresh_token"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Until(got.ExpiresAt) - defaultExpiresIn; d < -time.Minute || d > 0 {
+		t.Errorf("ExpiresAt = %s, want about now+%s", got.ExpiresAt.Format(time.RFC3339), defaultExpiresIn)
+	}
+}
+
+func TestCacheReadsDuringWritesAreWhole(t *testing.T) {
+	isolate(t)
+	t.Setenv("OURA_PAT", "tok")
+	t.Setenv("OURA_HR_CACHE_TTL", "3600")
+	values := []string{strings.Repeat("a", 256<<10) + "\n", strings.Repeat("b", 256<<10) + "\n"}
+	cache := cachePath("")
+	if err := writeFileAtomic(cache, []byte(values[0]), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	const rounds = 200
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := range rounds {
+			unlock := lockCache(cache, true)
+			if err := writeFileAtomic(cache, []byte(values[i%2]), 0o600); err != nil {
+				t.Error(err)
+			}
+			unlock()
+		}
+	}()
+	fetch := func(context.Context, *apiClient) (string, time.Time, error) {
+		return "", time.Time{}, errors.New("cache should have been fresh")
+	}
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range rounds {
+				var buf bytes.Buffer
+				if err := runCachedUntil(context.Background(), &buf, "", fetch); err != nil {
+					t.Error(err)
+					return
+				}
+				if got := buf.String(); got != values[0] && got != values[1] {
+					t.Errorf("read a partial value of %d bytes", len(got))
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// TestRotatedSaveFailureKeepsNewTokens covers a refresh that rotates the
+// refresh token but can't save it: the client must go on with the new
+// tokens, and save them once saving works again, since the old refresh
+// token is spent.
+func TestRotatedSaveFailureKeepsNewTokens(t *testing.T) {
+	dir := isolate(t)
+	tokDir := dir + "/tokens"
+	if err := os.Mkdir(tokDir, 0o700); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("OURA_TOKEN_FILE", tokDir+"/oura-tokens.json")
+	var hits atomic.Int32
+	tokenServer(t, &hits, func(n int32) string {
+		// Take the directory away mid-refresh, so the save can't write
+		// anything under it, as root as well as otherwise
+		if err := os.Rename(tokDir, tokDir+".away"); err != nil {
+			t.Error(err)
+		}
+		if err := os.WriteFile(tokDir, nil, 0o600); err != nil {
+			t.Error(err)
+		}
+		return fmt.Sprintf(`{"access_token":"access-%d","refresh_token":"refresh-%d","expires_in":3600}`, n, n)
+	})
+	old := &storedTokens{AccessToken: "access-0", RefreshToken: "refresh-0", ExpiresAt: time.Now().Add(-time.Minute)}
+	if err := saveTokens(old); err != nil {
+		t.Fatal(err)
+	}
+
+	c := &apiClient{clientID: "id", tokens: old, accessToken: old.AccessToken}
+	err := c.refreshTokens()
+	if !reportable(err) {
+		t.Fatalf("refreshTokens = %v, want the save error", err)
+	}
+	if c.tokens.RefreshToken != "refresh-1" || c.accessToken != "access-1" {
+		t.Fatalf("client tokens = %+v, want the rotated ones", c.tokens)
+	}
+
+	if err := os.Remove(tokDir); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tokDir+".away", tokDir); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.refreshIfExpiring(); err != nil {
+		t.Fatalf("retrying the save: %v", err)
+	}
+	got, err := loadTokens()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.RefreshToken != "refresh-1" {
+		t.Errorf("stored refresh token %q, want the rotated refresh-1", got.RefreshToken)
+	}
+	if n := hits.Load(); n != 1 {
+		t.Errorf("token endpoint hit %d times, want 1", n)
+	}
+}
+
+func TestBackupUsesCurrentEncoding(t *testing.T) {
+	for _, pass := range []string{"", "secret"} {
+		t.Run(fmt.Sprintf("passphrase=%q", pass), func(t *testing.T) {
+			isolate(t)
+			old := &storedTokens{AccessToken: "access-0", RefreshToken: "refresh-0"}
+			if err := saveTokens(old); err != nil {
+				t.Fatal(err)
+			}
+			t.Setenv("OURA_TOKEN_PASSPHRASE", pass)
+			if err := saveTokens(&storedTokens{AccessToken: "access-1", RefreshToken: "refresh-1"}); err != nil {
+				t.Fatal(err)
+			}
+
+			data, err := os.ReadFile(tokenBackupPath())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if encrypted := bytes.HasPrefix(data, []byte(tokenFileMagic)); encrypted != (pass != "") {
+				t.Errorf("backup encrypted = %t, want %t", encrypted, pass != "")
+			}
+			if pass != "" && bytes.Contains(data, []byte(old.RefreshToken)) {
+				t.Error("backup holds the refresh token in plaintext")
+			}
+			prev, err := readTokenFile(tokenBackupPath())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if prev.RefreshToken != old.RefreshToken {
+				t.Errorf("backup refresh token %q, want %q", prev.RefreshToken, old.RefreshToken)
+			}
+		})
+	}
+}
+
+func TestWatchInterruptedPollKeepsOutput(t *testing.T) {
+	isolate(t)
+	out := t.TempDir() + "/out"
+	prev := *outFlag
+	*outFlag = out
+	t.Cleanup(func() { *outFlag = prev })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var polls atomic.Int32
+	render := func(ctx context.Context, _ *apiClient) (string, time.Time, error) {
+		if polls.Add(1) == 1 {
+			return "♥ 62", time.Time{}, nil
+		}
+		cancel() // interrupted while the fetch is in flight
+		<-ctx.Done()
+		return "", time.Time{}, ctx.Err()
+	}
+	watch(ctx, &apiClient{}, render, time.Millisecond)
+
+	got, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "♥ 62\n" {
+		t.Errorf("--out file = %q after an interrupted poll, want the last reading", got)
+	}
+}
+
+func TestConfigBooleans(t *testing.T) {
+	dir := isolate(t)
+	path := dir + "/config.toml"
+	if err := os.WriteFile(path, []byte("plain = true\ntrend = false\nsandbox = 1\ncache_ttl = 90\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"OURA_HR_PLAIN", "OURA_HR_TREND", "OURA_SANDBOX", "OURA_HR_CACHE_TTL"} {
+		t.Setenv(name, "")
+	}
+	t.Setenv("OURA_CONFIG", path)
+	t.Cleanup(func() { fileSettings = nil })
+	if err := loadConfig(); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, want := range map[string]string{"OURA_HR_PLAIN": "1", "OURA_HR_TREND": "", "OURA_SANDBOX": "1", "OURA_HR_CACHE_TTL": "90"} {
+		if got := setting(name); got != want {
+			t.Errorf("setting(%s) = %q, want %q", name, got, want)
+		}
+	}
+	if trendEnabled() {
+		t.Error("trend = false enabled the trend")
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+)
+
+// pingCommand makes a single live heart-rate request and reports its
+// status, round-trip time and entry count, for health checks and
+// measuring API latency. It exits with the status-bar exit codes.
+func pingCommand(args []string) {
+	fs := flag.NewFlagSet("ping", flag.ExitOnError)
+	addProfileFlag(fs)
+	fs.Parse(args)
+
+	client, err := newClient()
+	if err != nil {
+		exitWithCode(err)
+	}
+	now := time.Now().UTC()
+	start := time.Now()
+	resp, err := client.do(context.Background(), apiEndpoint(heartRateEndpoint)+"?"+heartRateQuery(now.Add(-window()), now).Encode(), "")
+	if err != nil {
+		exitWithCode(err)
+	}
+	defer resp.Body.Close()
+	body, err := readBody(resp.Body)
+	if err != nil {
+		exitWithCode(err)
+	}
+	elapsed := time.Since(start).Round(time.Millisecond)
+
+	if resp.StatusCode != http.StatusOK {
+		fmt.Printf("HTTP %s in %s\n", resp.Status, elapsed)
+		os.Exit(exitNetwork)
+	}
+	var p page[hrEntry]
+	if err := json.Unmarshal(body, &p); err != nil {
+		exitWithCode(fmt.Errorf("HTTP %s in %s, but the response isn't valid: %w", resp.Status, elapsed, err))
+	}
+	more := ""
+	if p.NextToken != "" {
+		more = ", more on further pages"
+	}
+	fmt.Printf("HTTP %s in %s, %d entries%s\n", resp.Status, elapsed, len(p.Data), more)
+}
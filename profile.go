@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// validProfile matches profile names. They end up in file names, so
+// anything that could take a path out of the cache directory is rejected.
+var validProfile = regexp.MustCompile(`^[A-Za-z0-9_-]*$`)
+
+// profile is the active account, from --profile or OURA_PROFILE. The
+// default profile is "". An invalid OURA_PROFILE is a usage error.
+func profile() string {
+	p := *profileFlag
+	if p == "" {
+		p = setting("OURA_PROFILE")
+	}
+	if !validProfile.MatchString(p) {
+		fmt.Fprintf(os.Stderr, "Error: invalid profile %q: use only letters, digits, - and _\n", p)
+		os.Exit(2)
+	}
+	return p
+}
+
+// profileValue is the --profile flag, rejecting invalid names as it's
+// parsed.
+type profileValue struct{ p *string }
+
+func (v profileValue) String() string {
+	if v.p == nil {
+		return ""
+	}
+	return *v.p
+}
+
+func (v profileValue) Set(s string) error {
+	if !validProfile.MatchString(s) {
+		return errors.New("use only letters, digits, - and _")
+	}
+	*v.p = s
+	return nil
+}
+
+// newProfileFlag defines --profile on fs.
+func newProfileFlag(fs *flag.FlagSet) *string {
+	p := new(string)
+	fs.Var(profileValue{p}, "profile", profileUsage)
+	return p
+}
+
+// addProfileFlag lets a subcommand take --profile like the default command.
+func addProfileFlag(fs *flag.FlagSet) {
+	fs.Var(profileValue{profileFlag}, "profile", profileUsage)
+}
+
+// profileName suffixes a file name with the active profile, before any
+// extension: oura-tokens.json becomes oura-tokens-work.json. The default
+// profile keeps the name unchanged.
+func profileName(name string) string {
+	p := profile()
+	if p == "" {
+		return name
+	}
+	ext := filepath.Ext(name)
+	return strings.TrimSuffix(name, ext) + "-" + p + ext
+}
+
+// cacheFiles lists the active profile's cached output, history, alert and
+// smoothing files, whether or not they exist.
+func cacheFiles() []string {
+	base := cacheBase()
+	paths := []string{base, historyPath(), alertPath(), smoothPath(), etagPath()}
+	hashed, _ := filepath.Glob(base + "-????????")
+	for _, p := range hashed {
+		// Another profile's base name can match the glob too
+		if _, err := hex.DecodeString(strings.TrimPrefix(p, base+"-")); err == nil {
+			paths = append(paths, p)
+		}
+	}
+	return paths
+}
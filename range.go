@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// parseDate accepts RFC3339 or a plain YYYY-MM-DD date in local time.
+func parseDate(v string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	t, err := time.ParseInLocation(time.DateOnly, v, time.Local)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date %q: want RFC3339 or YYYY-MM-DD", v)
+	}
+	return t, nil
+}
+
+// parseRange resolves --since/--until. A missing until means now and a
+// missing since means one window before until.
+func parseRange(since, until string) (start, end time.Time, err error) {
+	end = time.Now()
+	if until != "" {
+		if end, err = parseDate(until); err != nil {
+			return
+		}
+	}
+	start = end.Add(-window())
+	if since != "" {
+		if start, err = parseDate(since); err != nil {
+			return
+		}
+	}
+	if !start.Before(end) {
+		err = fmt.Errorf("--since %s is not before --until %s", start.Format(time.RFC3339), end.Format(time.RFC3339))
+	}
+	return start.UTC(), end.UTC(), err
+}
+
+// writeEntries prints one "timestamp bpm source" line per entry, or a JSON
+// array in json mode.
+func writeEntries(w io.Writer, entries []hrEntry, format string) error {
+	if format == "json" {
+		shown := make([]hrEntry, len(entries))
+		for i, e := range entries {
+			shown[i] = displayEntry(e, true)
+		}
+		data, err := json.Marshal(shown)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "%s\n", data)
+		return err
+	}
+	for _, e := range entries {
+		e = displayEntry(e, false)
+		if _, err := fmt.Fprintf(w, "%s %d %s\n", e.Timestamp, e.BPM, e.Source); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runRange is the ad-hoc query behind --since/--until. It bypasses the cache
+// and, unlike the status-bar path, reports errors.
+func runRange(ctx context.Context, cfg config, format func(reading) (string, error), w io.Writer) error {
+	start, end, err := parseRange(cfg.since, cfg.until)
+	if err != nil {
+		return err
+	}
+	client, err := newClient()
+	if err != nil {
+		return err
+	}
+	entries, err := client.heartRate(ctx, start, end)
+	if err != nil {
+		return err
+	}
+	debugf("got %d entries", len(entries))
+
+	if mode := aggregateMode(); mode != "" && len(entries) > 0 {
+		output, err := format(reading{hrEntry: aggregate(entries, mode)})
+		if err != nil {
+			return formatError{err}
+		}
+		_, err = io.WriteString(w, output)
+		return err
+	}
+	return writeEntries(w, entries, cfg.format)
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+)
+
+// rawCommand prints the unparsed heart-rate response for the current
+// window, bypassing the cache, with the HTTP status on stderr. It's meant
+// for debugging when the API changes shape.
+func rawCommand(args []string) {
+	fs := flag.NewFlagSet("raw", flag.ExitOnError)
+	pretty := fs.Bool("pretty", false, "indent the JSON response")
+	addProfileFlag(fs)
+	fs.Parse(args)
+
+	client, err := newClient()
+	exitOnError(err)
+
+	now := time.Now().UTC()
+	resp, err := client.do(context.Background(), apiEndpoint(heartRateEndpoint)+"?"+heartRateQuery(now.Add(-window()), now).Encode(), "")
+	exitOnError(err)
+	defer resp.Body.Close()
+	body, err := readBody(resp.Body)
+	exitOnError(err)
+
+	fmt.Fprintf(os.Stderr, "HTTP %s\n", resp.Status)
+	if *pretty {
+		var buf bytes.Buffer
+		if err := json.Indent(&buf, body, "", "  "); err == nil {
+			body = buf.Bytes()
+		}
+	}
+	os.Stdout.Write(body)
+	if !bytes.HasSuffix(body, []byte("\n")) {
+		fmt.Println()
+	}
+	if resp.StatusCode != http.StatusOK {
+		os.Exit(1)
+	}
+}
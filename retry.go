@@ -0,0 +1,75 @@
+package main
+
+import (
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// retries is how many times a network error or 5xx response is retried.
+func retries() int { return max(0, envInt("OURA_HR_RETRIES", defaultRetries)) }
+
+// backoff is the wait before retry n (from 0): exponential from
+// retryBackoff, with jitter so clients that failed together don't retry
+// together.
+func backoff(n int) time.Duration {
+	d := retryBackoff << n
+	return d/2 + rand.N(d)
+}
+
+// retryAfter parses a Retry-After header (seconds or an HTTP date), capped
+// at maxRetryAfter.
+func retryAfter(v string) time.Duration {
+	d := time.Second
+	if n, err := strconv.Atoi(v); err == nil {
+		d = time.Duration(n) * time.Second
+	} else if t, err := http.ParseTime(v); err == nil {
+		d = time.Until(t)
+	}
+	return max(0, min(d, maxRetryAfter))
+}
+
+// doWithRetry retries requests rejected with 429 Too Many Requests up to
+// rateLimitRetries times, waiting as long as the server asks. Network
+// errors and 5xx responses are retried up to retries() times with backoff;
+// other 4xx responses won't get better, so they're returned right away.
+func doWithRetry(client *http.Client, req *http.Request) (*http.Response, error) {
+	var limited, failed int
+	for {
+		resp, err := client.Do(req)
+		var wait time.Duration
+		switch {
+		case req.Context().Err() != nil:
+			return resp, err
+		case err != nil || resp.StatusCode >= 500:
+			if failed == retries() {
+				return resp, err
+			}
+			wait = backoff(failed)
+			failed++
+			if err != nil {
+				debugf("%v, retrying in %s", err, wait)
+			} else {
+				debugf("HTTP %s, retrying in %s", resp.Status, wait)
+			}
+		case resp.StatusCode == http.StatusTooManyRequests:
+			if limited == rateLimitRetries {
+				return resp, nil
+			}
+			limited++
+			wait = retryAfter(resp.Header.Get("Retry-After"))
+			debugf("rate limited, retrying in %s", wait)
+		default:
+			return resp, nil
+		}
+		if resp != nil {
+			resp.Body.Close()
+		}
+		select {
+		case <-time.After(wait):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+	}
+}
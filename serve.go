@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const defaultServeAddr = "localhost:9099"
+
+// serveCommand exposes the latest reading over HTTP for dashboards. Each
+// request goes through the same cache as the CLI, so the API is hit at
+// most once per TTL however often it's polled.
+func serveCommand(args []string) {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	addr := fs.String("addr", defaultServeAddr, "address to listen on")
+	addProfileFlag(fs)
+	fs.Parse(args)
+
+	var latest latestReading
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(hrCollector{&latest})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/hr", func(w http.ResponseWriter, r *http.Request) {
+		data, err := latest.get(r.Context())
+		w.Header().Set("Content-Type", "application/json")
+		if err != nil {
+			debugf("serving /hr: %v", err)
+			w.WriteHeader(http.StatusServiceUnavailable)
+			json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			return
+		}
+		w.Write(data)
+	})
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "ok")
+	})
+	mux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	srv := &http.Server{Addr: *addr, Handler: mux}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+
+	fmt.Fprintf(os.Stderr, "Serving on http://%s/hr\n", *addr)
+	if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		exitOnError(err)
+	}
+}
+
+// latestReading fetches the reading as JSON through the cache. Calls are
+// serialized so concurrent misses share one fetch. A missing reading is an
+// error, never the OURA_HR_EMPTY placeholder, which isn't JSON.
+type latestReading struct{ mu sync.Mutex }
+
+func (l *latestReading) get(ctx context.Context) ([]byte, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var buf bytes.Buffer
+	err := run(ctx, config{format: "json", noEmpty: true}, &buf)
+	return buf.Bytes(), err
+}
+
+var (
+	bpmDesc = prometheus.NewDesc("oura_heart_rate_bpm",
+		"Latest heart rate reading in beats per minute.", nil, nil)
+	readingTimeDesc = prometheus.NewDesc("oura_last_reading_timestamp_seconds",
+		"Unix time of the latest heart rate reading.", nil, nil)
+)
+
+// hrCollector reports the latest reading on every scrape. It reads through
+// the cache, so scrapes only reach Oura once the TTL has passed; if there's
+// no reading the metrics are left out.
+type hrCollector struct{ latest *latestReading }
+
+func (hrCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- bpmDesc
+	ch <- readingTimeDesc
+}
+
+func (c hrCollector) Collect(ch chan<- prometheus.Metric) {
+	data, err := c.latest.get(context.Background())
+	var e hrEntry
+	if err == nil {
+		err = json.Unmarshal(data, &e)
+	}
+	if err != nil {
+		debugf("collecting metrics: %v", err)
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(bpmDesc, prometheus.GaugeValue, float64(e.BPM))
+	if ts := entryTime(e); !ts.IsZero() {
+		ch <- prometheus.MustNewConstMetric(readingTimeDesc, prometheus.GaugeValue, float64(ts.Unix()))
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"os"
+	"strconv"
+	"time"
+)
+
+const smoothFileName = "oura-hr-smooth.json"
+
+// smoothState is the last displayed value, kept unrounded so the smoothing
+// chain carries on exactly from run to run.
+type smoothState struct {
+	BPM       float64 `json:"bpm"`
+	Timestamp string  `json:"timestamp"`
+}
+
+func smoothPath() string { return statePath(smoothFileName) }
+
+// smoothAlpha is the OURA_HR_SMOOTH weight of a new reading, in (0, 1].
+// 0 disables smoothing.
+func smoothAlpha() float64 {
+	if v := setting("OURA_HR_SMOOTH"); v != "" {
+		if a, err := strconv.ParseFloat(v, 64); err == nil && a > 0 && a <= 1 {
+			return a
+		}
+	}
+	return 0
+}
+
+// smoothBPM blends e into the previously displayed value by exponential
+// smoothing and returns the rounded result. The same reading is never
+// blended in twice, and a previous value older than the window starts
+// the chain afresh.
+func smoothBPM(e hrEntry) int {
+	alpha := smoothAlpha()
+	if alpha == 0 {
+		return e.BPM
+	}
+
+	cur := smoothState{BPM: float64(e.BPM), Timestamp: e.Timestamp}
+	var prev smoothState
+	if data, err := os.ReadFile(smoothPath()); err == nil && json.Unmarshal(data, &prev) == nil {
+		prevAt := entryTime(hrEntry{Timestamp: prev.Timestamp})
+		switch {
+		case prev.Timestamp == e.Timestamp:
+			cur = prev
+		case time.Since(prevAt) < window():
+			cur.BPM = alpha*cur.BPM + (1-alpha)*prev.BPM
+		}
+	}
+
+	data, _ := json.Marshal(cur)
+	if err := ensureCacheDir(); err != nil {
+		debugf("not saving smoothed value: %v", err)
+	} else if err := writeFileAtomic(smoothPath(), data, 0o600); err != nil {
+		debugf("saving smoothed value: %v", err)
+	}
+	return int(math.Round(cur.BPM))
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+const defaultSummaryDays = 7
+
+// daySummary is one row of the summary; zero means no data.
+type daySummary struct {
+	resting, readiness int
+}
+
+// summaryCommand prints the resting heart rate and readiness of each of
+// the last days days, and their means.
+func summaryCommand(args []string) {
+	fs := flag.NewFlagSet("summary", flag.ExitOnError)
+	days := fs.Int("days", defaultSummaryDays, "number of days to summarize, including today")
+	addProfileFlag(fs)
+	fs.Parse(args)
+
+	if *days < 1 {
+		fmt.Fprintln(os.Stderr, "Error: --days must be at least 1")
+		os.Exit(2)
+	}
+	exitOnError(summary(context.Background(), os.Stdout, *days))
+}
+
+func summary(ctx context.Context, w io.Writer, days int) error {
+	client, err := newClient()
+	if err != nil {
+		return err
+	}
+	query := dayRange(days - 1)
+	periods, err := fetchAll[sleepPeriod](ctx, client, sleepEndpoint, query)
+	if err != nil {
+		return err
+	}
+	readiness, err := fetchAll[dailyReadiness](ctx, client, readinessEndpoint, query)
+	if err != nil {
+		return err
+	}
+	debugf("got %d sleep periods and %d readiness days", len(periods), len(readiness))
+
+	byDay := map[string]daySummary{}
+	for _, p := range periods {
+		// The lowest of the day's sleep periods, naps included
+		if s := byDay[p.Day]; p.LowestHeartRate > 0 && (s.resting == 0 || p.LowestHeartRate < s.resting) {
+			s.resting = p.LowestHeartRate
+			byDay[p.Day] = s
+		}
+	}
+	for _, r := range readiness {
+		if s := byDay[r.Day]; r.Score > 0 {
+			s.readiness = r.Score
+			byDay[r.Day] = s
+		}
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "day\tresting\treadiness\t")
+	var restingSum, restingN, readinessSum, readinessN int
+	for d := days - 1; d >= 0; d-- {
+		day := time.Now().AddDate(0, 0, -d).Format(time.DateOnly)
+		s := byDay[day]
+		fmt.Fprintf(tw, "%s\t%s\t%s\t\n", day, summaryValue(s.resting), summaryValue(s.readiness))
+		if s.resting > 0 {
+			restingSum, restingN = restingSum+s.resting, restingN+1
+		}
+		if s.readiness > 0 {
+			readinessSum, readinessN = readinessSum+s.readiness, readinessN+1
+		}
+	}
+	fmt.Fprintf(tw, "mean\t%s\t%s\t\n", summaryMean(restingSum, restingN), summaryMean(readinessSum, readinessN))
+	return tw.Flush()
+}
+
+func summaryValue(v int) string {
+	if v == 0 {
+		return "—"
+	}
+	return fmt.Sprint(v)
+}
+
+func summaryMean(sum, n int) string {
+	if n == 0 {
+		return "—"
+	}
+	return fmt.Sprintf("%.1f", float64(sum)/float64(n))
+}
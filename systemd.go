@@ -0,0 +1,101 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	serviceTemplate = `[Unit]
+Description=Fetch the Oura heart rate into the oura-hr cache
+After=network-online.target
+
+[Service]
+Type=oneshot
+# Credentials such as OURA_CLIENT_ID, one VAR=value per line
+EnvironmentFile=-%%h/.config/oura-hr/env
+ExecStart=%s
+StandardOutput=null
+`
+	timerTemplate = `[Unit]
+Description=Refresh the Oura heart rate every %s
+
+[Timer]
+OnBootSec=%s
+OnUnitActiveSec=%s
+AccuracySec=1s
+
+[Install]
+WantedBy=timers.target
+`
+)
+
+// systemdCommand writes a user service and timer that keep the cache warm,
+// so status bars reading it never wait on the API.
+func systemdCommand(args []string) {
+	fs := flag.NewFlagSet("install-systemd", flag.ExitOnError)
+	interval := fs.Duration("interval", time.Minute, "how often the timer runs the fetch")
+	printOnly := fs.Bool("print", false, "print the units instead of installing them")
+	addProfileFlag(fs)
+	fs.Parse(args)
+
+	if *interval < time.Second {
+		fmt.Fprintln(os.Stderr, "Error: --interval must be at least 1s.")
+		os.Exit(1)
+	}
+	exe, err := os.Executable()
+	exitOnError(err)
+	if exe, err = filepath.EvalSymlinks(exe); err != nil {
+		exitOnError(err)
+	}
+
+	// Update the cache regardless of its TTL
+	command := []string{exe, "--no-cache"}
+	if p := profile(); p != "" {
+		command = append(command, "--profile", p)
+	}
+	for i, arg := range command {
+		if strings.ContainsAny(arg, " \t\"'\\") {
+			command[i] = strconv.Quote(arg)
+		}
+	}
+	// systemd's unit timespans don't take Go's "1m0s" combined form
+	span := fmt.Sprintf("%ds", int(interval.Seconds()))
+	units := map[string]string{
+		"oura-hr.service": fmt.Sprintf(serviceTemplate, strings.Join(command, " ")),
+		"oura-hr.timer":   fmt.Sprintf(timerTemplate, *interval, span, span),
+	}
+
+	if *printOnly {
+		fmt.Printf("# oura-hr.service\n%s\n# oura-hr.timer\n%s", units["oura-hr.service"], units["oura-hr.timer"])
+		return
+	}
+
+	dir, err := systemdUserDir()
+	exitOnError(err)
+	exitOnError(os.MkdirAll(dir, 0o755))
+	for _, name := range []string{"oura-hr.service", "oura-hr.timer"} {
+		path := filepath.Join(dir, name)
+		exitOnError(writeFileAtomic(path, []byte(units[name]), 0o644))
+		fmt.Printf("Wrote %s\n", path)
+	}
+	fmt.Println("Enable it with: systemctl --user daemon-reload && systemctl --user enable --now oura-hr.timer")
+}
+
+// systemdUserDir is where systemd looks for user units.
+func systemdUserDir() (string, error) {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		dir = filepath.Join(home, ".config")
+	}
+	return filepath.Join(dir, "systemd", "user"), nil
+}
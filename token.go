@@ -0,0 +1,71 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// redact keeps the first and last 4 characters of a secret, enough to tell
+// tokens apart without revealing them. Short secrets are hidden entirely.
+func redact(s string) string {
+	switch {
+	case s == "":
+		return "(none)"
+	case len(s) < 16:
+		return strings.Repeat("*", len(s))
+	}
+	return s[:4] + strings.Repeat("*", 8) + s[len(s)-4:]
+}
+
+// tokenCommand shows the stored tokens, redacted, for debugging. It's the
+// safe alternative to reading the token file.
+func tokenCommand(args []string) {
+	fs := flag.NewFlagSet("token", flag.ExitOnError)
+	addProfileFlag(fs)
+	fs.Parse(args)
+
+	if pat := setting("OURA_PAT"); pat != "" {
+		fmt.Printf("OURA_PAT:      %s (used instead of the stored tokens)\n", redact(pat))
+	}
+
+	store := tokens()
+	t, err := store.load()
+	if errors.Is(err, os.ErrNotExist) {
+		fmt.Fprintf(os.Stderr, "No tokens in %s. Run `oura-hr setup` to authorize.\n", store.location())
+		os.Exit(exitSetup)
+	}
+	exitOnError(err)
+
+	fmt.Printf("Stored in:     %s\n", store.location())
+	fmt.Printf("Access token:  %s\n", redact(t.AccessToken))
+	fmt.Printf("Refresh token: %s\n", redact(t.RefreshToken))
+	fmt.Printf("Expires at:    %s\n", t.ExpiresAt.Local().Format(time.RFC1123))
+	if d := time.Until(t.ExpiresAt); d > 0 {
+		fmt.Printf("Status:        valid for %s\n", humanizeAge(d))
+	} else {
+		fmt.Printf("Status:        expired %s ago\n", humanizeAge(-d))
+	}
+}
+
+// refreshStoredTokens is --refresh-tokens: it refreshes the stored tokens
+// whether or not they're due, which keeps an unused refresh token from
+// expiring.
+func refreshStoredTokens() {
+	if setting("OURA_PAT") != "" {
+		fmt.Println("Using OURA_PAT, there are no tokens to refresh.")
+		return
+	}
+	t, err := loadTokens()
+	if err != nil {
+		exitWithCode(err)
+	}
+	c := &apiClient{clientID: setting("OURA_CLIENT_ID"), clientSecret: setting("OURA_CLIENT_SECRET"), tokens: t, accessToken: t.AccessToken}
+	if err := c.refreshTokens(); err != nil {
+		exitWithCode(err)
+	}
+	fmt.Printf("Refreshed tokens, valid until %s\n", c.tokens.ExpiresAt.Local().Format(time.RFC1123))
+}
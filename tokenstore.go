@@ -0,0 +1,253 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/zalando/go-keyring"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	keyringService = "oura-hr"
+	keyringUser    = "tokens"
+
+	// Encrypted token files start with this header followed by the scrypt
+	// salt, the AES-GCM nonce and the sealed JSON.
+	tokenFileMagic = "oura-hr-enc1\n"
+	saltSize       = 16
+)
+
+// tokenStore persists OAuth tokens. A missing token must be reported as
+// os.ErrNotExist so callers can tell "not set up" from a real failure.
+type tokenStore interface {
+	load() (*storedTokens, error)
+	save(t *storedTokens) error
+	remove() error
+	location() string
+}
+
+func tokens() tokenStore {
+	if setting("OURA_TOKEN_STORE") == "keyring" {
+		return keyringStore{}
+	}
+	return fileStore{}
+}
+
+func loadTokens() (*storedTokens, error) { return tokens().load() }
+func saveTokens(t *storedTokens) error   { return tokens().save(t) }
+
+// storeError marks failures of the token store itself, such as an
+// unavailable keyring or a wrong passphrase. Unlike a missing token these
+// mean the setup is broken, so they're surfaced even on the otherwise
+// silent status-bar path.
+type storeError struct{ err error }
+
+func (e storeError) Error() string { return e.err.Error() }
+func (e storeError) Unwrap() error { return e.err }
+
+// decodeTokens parses stored tokens. Unparseable data, such as a file
+// truncated mid-write, or tokens without an access token would only fail
+// later on a doomed request, so they're reported as a broken store.
+func decodeTokens(data []byte) (*storedTokens, error) {
+	var t storedTokens
+	if err := json.Unmarshal(data, &t); err != nil {
+		return nil, storeError{fmt.Errorf("stored tokens are corrupt (%v), run `oura-hr setup` again", err)}
+	}
+	if t.AccessToken == "" {
+		return nil, storeError{errors.New("stored tokens have no access token, run `oura-hr setup` again")}
+	}
+	return &t, nil
+}
+
+// fileStore keeps tokens in tokenPath(), encrypted when
+// OURA_TOKEN_PASSPHRASE is set. Plaintext files are still read so existing
+// setups keep working; they're encrypted on the next save. The previous
+// tokens are kept in tokenBackupPath() and used if the file turns out to
+// be corrupt.
+type fileStore struct{}
+
+func tokenBackupPath() string { return tokenPath() + ".bak" }
+
+func (fileStore) load() (*storedTokens, error) {
+	if !filepath.IsAbs(tokenPath()) {
+		if err := checkCacheDir(); err != nil {
+			return nil, err
+		}
+	}
+	t, err := readTokenFile(tokenPath())
+	var se storeError
+	if errors.As(err, &se) {
+		if prev, berr := readTokenFile(tokenBackupPath()); berr == nil {
+			warnf("%v; using the previous tokens from %s", err, tokenBackupPath())
+			return prev, nil
+		}
+	}
+	return t, err
+}
+
+func readTokenFile(path string) (*storedTokens, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.HasPrefix(data, []byte(tokenFileMagic)) {
+		if data, err = decryptTokens(data, setting("OURA_TOKEN_PASSPHRASE")); err != nil {
+			return nil, storeError{err}
+		}
+	}
+	return decodeTokens(data)
+}
+
+// backup keeps the current tokens in tokenBackupPath() before the file is
+// replaced, so a rotated refresh token that can't be used leaves the
+// previous one to recover with. Only readable tokens are backed up, so a
+// corrupt file never replaces a good backup, and they're re-encoded like
+// the new file, so a plaintext file being encrypted leaves no plaintext
+// copy behind.
+func (fileStore) backup() {
+	prev, err := readTokenFile(tokenPath())
+	if err != nil {
+		return
+	}
+	data, err := encodeTokens(prev)
+	if err == nil {
+		err = writeFileAtomic(tokenBackupPath(), data, 0o600)
+	}
+	if err != nil {
+		debugf("backing up tokens: %v", err)
+	}
+}
+
+// encodeTokens is the token file contents for t, encrypted when
+// OURA_TOKEN_PASSPHRASE is set.
+func encodeTokens(t *storedTokens) ([]byte, error) {
+	data, _ := json.Marshal(t)
+	if pass := setting("OURA_TOKEN_PASSPHRASE"); pass != "" {
+		var err error
+		if data, err = encryptTokens(data, pass); err != nil {
+			return nil, storeError{err}
+		}
+	}
+	return data, nil
+}
+
+func (s fileStore) save(t *storedTokens) error {
+	data, err := encodeTokens(t)
+	if err != nil {
+		return err
+	}
+	if err := ensureCacheDir(); err != nil {
+		return storeError{err}
+	}
+	s.backup()
+	if err := writeFileAtomic(tokenPath(), data, 0o600); err != nil {
+		return storeError{err}
+	}
+	return nil
+}
+
+func (fileStore) remove() error {
+	os.Remove(tokenBackupPath())
+	return os.Remove(tokenPath())
+}
+
+func (fileStore) location() string { return tokenPath() }
+
+func tokenCipher(pass string, salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key([]byte(pass), salt, 1<<15, 8, 1, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func encryptTokens(plain []byte, pass string) ([]byte, error) {
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	aead, err := tokenCipher(pass, salt)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	out := append([]byte(tokenFileMagic), salt...)
+	out = append(out, nonce...)
+	return aead.Seal(out, nonce, plain, []byte(tokenFileMagic)), nil
+}
+
+func decryptTokens(data []byte, pass string) ([]byte, error) {
+	if pass == "" {
+		return nil, errors.New("token file is encrypted; set OURA_TOKEN_PASSPHRASE")
+	}
+	data = data[len(tokenFileMagic):]
+	if len(data) < saltSize {
+		return nil, errors.New("encrypted token file is truncated")
+	}
+	aead, err := tokenCipher(pass, data[:saltSize])
+	if err != nil {
+		return nil, err
+	}
+	data = data[saltSize:]
+	if len(data) < aead.NonceSize() {
+		return nil, errors.New("encrypted token file is truncated")
+	}
+	plain, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], []byte(tokenFileMagic))
+	if err != nil {
+		return nil, errors.New("could not decrypt token file: wrong OURA_TOKEN_PASSPHRASE?")
+	}
+	return plain, nil
+}
+
+type keyringStore struct{}
+
+func keyringError(err error) error { return storeError{fmt.Errorf("keyring: %w", err)} }
+
+func (keyringStore) load() (*storedTokens, error) {
+	data, err := keyring.Get(keyringService, profileName(keyringUser))
+	if errors.Is(err, keyring.ErrNotFound) {
+		return nil, os.ErrNotExist
+	}
+	if err != nil {
+		return nil, keyringError(err)
+	}
+	return decodeTokens([]byte(data))
+}
+
+func (keyringStore) save(t *storedTokens) error {
+	data, _ := json.Marshal(t)
+	if err := keyring.Set(keyringService, profileName(keyringUser), string(data)); err != nil {
+		return keyringError(err)
+	}
+	return nil
+}
+
+func (keyringStore) remove() error {
+	err := keyring.Delete(keyringService, profileName(keyringUser))
+	if errors.Is(err, keyring.ErrNotFound) {
+		return os.ErrNotExist
+	}
+	if err != nil {
+		return keyringError(err)
+	}
+	return nil
+}
+
+func (keyringStore) location() string {
+	return fmt.Sprintf("system keyring (service %q)", keyringService)
+}
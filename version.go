@@ -0,0 +1,27 @@
+package main
+
+import "fmt"
+
+// Set at build time, e.g.
+//
+//	go build -ldflags "-X main.version=v1.2.0 -X main.commit=$(git rev-parse --short HEAD) -X main.date=$(date -u +%F)"
+var (
+	version = "dev"
+	commit  = ""
+	date    = ""
+)
+
+// versionString is the version, followed by the commit and build date when
+// they were set.
+func versionString() string {
+	s := version
+	switch {
+	case commit != "" && date != "":
+		s += fmt.Sprintf(" (%s, built %s)", commit, date)
+	case commit != "":
+		s += fmt.Sprintf(" (%s)", commit)
+	case date != "":
+		s += fmt.Sprintf(" (built %s)", date)
+	}
+	return s
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const defaultWatchInterval = 30 * time.Second
+
+// watchCommand polls and prints a line per update until interrupted, for
+// bars with persistent modules such as i3blocks. It accepts the same output
+// flags as the default command.
+func watchCommand(args []string) {
+	fs := flag.NewFlagSet("watch", flag.ExitOnError)
+	interval := fs.Duration("interval", defaultWatchInterval, "time between polls")
+	flag.VisitAll(func(f *flag.Flag) { fs.Var(f.Value, f.Name, f.Usage) })
+	fs.Parse(args)
+
+	formatName := outputFormat()
+	format, err := newFormatter(formatName)
+	if err != nil {
+		exitOnError(formatError{err})
+	}
+	_, render := heartRateOutput(formatName, format)
+
+	client, err := newClient()
+	if err != nil {
+		exitWithCode(err)
+	}
+
+	// Interrupting also cancels a poll that's in flight
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	watch(ctx, client, render, *interval)
+}
+
+// watch emits render's output every interval until ctx is done. A poll
+// cut short by ctx emits nothing, so the last line, or the --out file,
+// is kept rather than blanked.
+func watch(ctx context.Context, client *apiClient, render func(context.Context, *apiClient) (string, time.Time, error), interval time.Duration) {
+	emit := func(output string) {
+		if err := writeOutput(output); err != nil {
+			warnf("%v", err)
+		}
+	}
+	// poll renders and emits one update. It reports false if ctx ended it.
+	poll := func() bool {
+		output, _, err := render(ctx, client)
+		switch {
+		case ctx.Err() != nil:
+			return false
+		case errors.Is(err, errNoData):
+			debugf("no data")
+			logPoll("no reading: %v", err)
+			if p := setting("OURA_HR_EMPTY"); p != "" {
+				emit(p + "\n")
+			}
+		case err != nil:
+			warnf("%v", err)
+			logPoll("no reading: %v", err)
+		default:
+			if !strings.HasSuffix(output, "\n") {
+				output += "\n"
+			}
+			emit(output)
+		}
+		return true
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		err := client.refreshIfExpiring()
+		if err != nil {
+			warnf("refreshing tokens: %v", err)
+			logPoll("refreshing tokens: %v", err)
+		}
+		// Refreshed tokens that couldn't be saved are still good to poll with
+		if (err == nil || client.unsaved) && !poll() {
+			return
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	workoutEndpoint = "workout"
+	workoutGlyph    = "🏃"
+)
+
+// workout is one recorded workout. The heart-rate fields are only there
+// for workouts tracked with heart rate.
+type workout struct {
+	Activity         string  `json:"activity"`
+	Intensity        string  `json:"intensity"` // easy, moderate or hard
+	StartDatetime    string  `json:"start_datetime"`
+	EndDatetime      string  `json:"end_datetime"`
+	AverageHeartRate float64 `json:"average_heart_rate"`
+	MaxHeartRate     int     `json:"max_heart_rate"`
+}
+
+// summary renders w as e.g. "🏃 running 45m (moderate, ♥ 142/171)".
+func (w workout) summary() string {
+	var parts []string
+	if w.Intensity != "" {
+		parts = append(parts, w.Intensity)
+	}
+	switch {
+	case w.AverageHeartRate > 0 && w.MaxHeartRate > 0:
+		parts = append(parts, fmt.Sprintf("%s %.0f/%d", defaultGlyph, w.AverageHeartRate, w.MaxHeartRate))
+	case w.AverageHeartRate > 0:
+		parts = append(parts, fmt.Sprintf("%s %.0f", defaultGlyph, w.AverageHeartRate))
+	}
+
+	s := workoutGlyph + " " + strings.ReplaceAll(w.Activity, "_", " ")
+	start, err1 := time.Parse(time.RFC3339, w.StartDatetime)
+	end, err2 := time.Parse(time.RFC3339, w.EndDatetime)
+	if err1 == nil && err2 == nil && end.After(start) {
+		s += " " + humanizeAge(end.Sub(start))
+	}
+	if len(parts) > 0 {
+		s += " (" + strings.Join(parts, ", ") + ")"
+	}
+	return s + "\n"
+}
+
+func workoutCommand(args []string) {
+	fs := flag.NewFlagSet("workout", flag.ExitOnError)
+	addProfileFlag(fs)
+	fs.Parse(args)
+
+	exitOnError(runCached(context.Background(), os.Stdout, "workout", func(ctx context.Context, client *apiClient) (string, error) {
+		workouts, err := fetchAll[workout](ctx, client, workoutEndpoint, dayRange(2))
+		if err != nil {
+			return "", err
+		}
+		debugf("got %d workouts", len(workouts))
+
+		// Compared as times, as the end times can carry different offsets
+		var latest *workout
+		var latestEnd time.Time
+		for i, w := range workouts {
+			end, err := time.Parse(time.RFC3339, w.EndDatetime)
+			if err != nil {
+				debugf("workout end %q: %v", w.EndDatetime, err)
+			}
+			if latest == nil || !end.Before(latestEnd) {
+				latest, latestEnd = &workouts[i], end
+			}
+		}
+		if latest == nil {
+			return "", errNoData
+		}
+		return latest.summary(), nil
+	}))
+}
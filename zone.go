@@ -0,0 +1,55 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+)
+
+// defaultZoneBands are the lower bounds of Z2–Z5 as a percentage of max
+// HR; anything below the first is Z1.
+var defaultZoneBands = []int{60, 70, 80, 90}
+
+// maxHR is OURA_MAX_HR, or 220 minus OURA_AGE, or 0 if neither is set.
+func maxHR() int {
+	if n := envInt("OURA_MAX_HR", 0); n > 0 {
+		return n
+	}
+	if age := envInt("OURA_AGE", 0); age > 0 {
+		return 220 - age
+	}
+	return 0
+}
+
+// zoneBands reads OURA_HR_ZONES, four comma-separated ascending
+// percentages, falling back to defaultZoneBands if it's unset or invalid.
+func zoneBands() []int {
+	v := setting("OURA_HR_ZONES")
+	if v == "" {
+		return defaultZoneBands
+	}
+	parts := strings.Split(v, ",")
+	if len(parts) != len(defaultZoneBands) {
+		return defaultZoneBands
+	}
+	bands := make([]int, len(parts))
+	for i, p := range parts {
+		n, err := strconv.Atoi(strings.TrimSpace(p))
+		if err != nil || (i > 0 && n <= bands[i-1]) {
+			return defaultZoneBands
+		}
+		bands[i] = n
+	}
+	return bands
+}
+
+// hrZone returns the training zone, 1–5, of bpm given maxHR.
+func hrZone(bpm, maxHR int) int {
+	pct := bpm * 100 / maxHR
+	zone := 1
+	for _, lower := range zoneBands() {
+		if pct >= lower {
+			zone++
+		}
+	}
+	return zone
+}